@@ -0,0 +1,152 @@
+package mbserver
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+)
+
+// modbusRoleOID is the X.509 v3 extension holding the role of a client in the
+// Modbus/TCP Security protocol.
+var modbusRoleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// AddressRange is an inclusive range of coil, input or register addresses.
+type AddressRange struct {
+	Start uint16
+	End   uint16
+}
+
+// AuthorizationRule lists what a role may access. An empty list allows all.
+type AuthorizationRule struct {
+	Functions []uint8
+	UnitIDs   []byte
+	Addresses []AddressRange
+}
+
+// AuthorizationPolicy maps a role to its authorization rule. Requests received
+// without a client certificate role are authorized as the empty role.
+type AuthorizationPolicy map[string]AuthorizationRule
+
+// SetAuthorizationPolicy sets the policy checked before every request is
+// executed. Requests for a role not in the policy, or for a function or unit
+// ID not allowed, are answered with IllegalFunction; requests for addresses
+// not allowed with IllegalDataAddress. Roles limited to addresses may only
+// use functions 1 to 6, 15, 16, 22 and 23, which address ranges are known;
+// other functions are answered with IllegalFunction. A nil policy allows all
+// requests.
+func (s *Server) SetAuthorizationPolicy(policy AuthorizationPolicy) {
+	s.authorization = policy
+}
+
+// Role returns the Modbus/TCP Security role of the client that sent the
+// request, empty if the client certificate holds none.
+func (r *Request) Role() string {
+	return r.role
+}
+
+// certificateRole returns the role held by the Modbus role extension of a
+// client certificate, empty if the certificate has no role.
+func certificateRole(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(modbusRoleOID) {
+			continue
+		}
+		var role string
+		rest, err := asn1.UnmarshalWithParams(ext.Value, &role, "utf8")
+		if err != nil {
+			return "", fmt.Errorf("bad Modbus role extension: %v", err)
+		}
+		if len(rest) != 0 {
+			return "", fmt.Errorf("bad Modbus role extension: trailing data")
+		}
+		return role, nil
+	}
+	return "", nil
+}
+
+// authorize checks the request against the authorization policy.
+func (s *Server) authorize(request *Request) *Exception {
+	if s.authorization == nil {
+		return &Success
+	}
+	rule, ok := s.authorization[request.role]
+	if !ok {
+		return &IllegalFunction
+	}
+	if len(rule.Functions) > 0 && !containsByte(rule.Functions, request.frame.GetFunction()) {
+		return &IllegalFunction
+	}
+	if len(rule.UnitIDs) > 0 && !containsByte(rule.UnitIDs, request.frame.GetAddress()) {
+		return &IllegalFunction
+	}
+	if len(rule.Addresses) > 0 {
+		ranges, ok := requestAddressRanges(request.frame)
+		if !ok {
+			return &IllegalFunction
+		}
+		for _, requested := range ranges {
+			if !rule.allowsAddresses(requested) {
+				return &IllegalDataAddress
+			}
+		}
+	}
+	return &Success
+}
+
+// allowsAddresses returns true if one of the allowed ranges holds the whole
+// requested range.
+func (rule AuthorizationRule) allowsAddresses(requested AddressRange) bool {
+	for _, allowed := range rule.Addresses {
+		if requested.Start >= allowed.Start && requested.End <= allowed.End {
+			return true
+		}
+	}
+	return false
+}
+
+// requestAddressRanges returns the address ranges accessed by a request for
+// the functions of the data model. It returns false if the ranges are not
+// known, for other functions or malformed requests.
+func requestAddressRanges(frame Framer) ([]AddressRange, bool) {
+	data := frame.GetData()
+	span := func(i int, number uint16) AddressRange {
+		start := binary.BigEndian.Uint16(data[i : i+2])
+		end := uint32(start) + uint32(number) - 1
+		if number == 0 {
+			end = uint32(start)
+		}
+		if end > 65535 {
+			end = 65535
+		}
+		return AddressRange{start, uint16(end)}
+	}
+
+	switch frame.GetFunction() {
+	case 1, 2, 3, 4, 15, 16:
+		if len(data) >= 4 {
+			return []AddressRange{span(0, binary.BigEndian.Uint16(data[2:4]))}, true
+		}
+	case 5, 6, 22:
+		if len(data) >= 2 {
+			return []AddressRange{span(0, 1)}, true
+		}
+	case 23:
+		if len(data) >= 8 {
+			return []AddressRange{
+				span(0, binary.BigEndian.Uint16(data[2:4])),
+				span(4, binary.BigEndian.Uint16(data[6:8])),
+			}, true
+		}
+	}
+	return nil, false
+}
+
+func containsByte(values []byte, value byte) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
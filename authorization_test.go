@@ -0,0 +1,209 @@
+package mbserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed certificate holding a Modbus role.
+func testCertificate(t *testing.T, role string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mbserver test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if role != "" {
+		value, err := asn1.MarshalWithParams(role, "utf8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: modbusRoleOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertificateRole(t *testing.T) {
+	cert, err := x509.ParseCertificate(testCertificate(t, "Operator").Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, err := certificateRole(cert)
+	if err != nil || role != "Operator" {
+		t.Errorf("expected Operator, got %v (%v)", role, err)
+	}
+
+	cert, err = x509.ParseCertificate(testCertificate(t, "").Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, err = certificateRole(cert)
+	if err != nil || role != "" {
+		t.Errorf("expected no role, got %v (%v)", role, err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	var LowerID, UpperID byte = 1, 2
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.SetAuthorizationPolicy(AuthorizationPolicy{
+		"Operator": {
+			Functions: []uint8{3, 6},
+			UnitIDs:   []byte{1},
+			Addresses: []AddressRange{{0, 9}},
+		},
+	})
+
+	var frame TCPFrame
+	frame.Device = 1
+	var req Request
+	req.frame = &frame
+	req.role = "Operator"
+
+	tests := []struct {
+		role     string
+		device   byte
+		function uint8
+		register uint16
+		number   uint16
+		expect   Exception
+	}{
+		{"Operator", 1, 3, 0, 10, Success},
+		{"Operator", 1, 3, 5, 6, IllegalDataAddress},
+		{"Operator", 1, 6, 10, 1, IllegalDataAddress},
+		{"Operator", 1, 4, 0, 1, IllegalFunction},
+		{"Operator", 2, 3, 0, 1, IllegalFunction},
+		{"", 1, 3, 0, 1, IllegalFunction},
+	}
+	for _, test := range tests {
+		req.role = test.role
+		frame.Device = test.device
+		frame.Function = test.function
+		SetDataWithRegisterAndNumber(&frame, test.register, test.number)
+		response := s.handle(&req)
+		exception := GetException(response)
+		if exception != test.expect {
+			t.Errorf("%+v: expected %v, got %v", test, test.expect.String(), exception.String())
+		}
+	}
+}
+
+func TestAuthorizeUnknownAddresses(t *testing.T) {
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.SetAuthorizationPolicy(AuthorizationPolicy{
+		"Limited": {Addresses: []AddressRange{{0, 9}}},
+	})
+	slave, _ := s.Slave(1)
+	slave.SetFileRecords(4, 0, 0, 0)
+	slave.PushFIFO(0, 1)
+
+	tests := []struct {
+		function uint8
+		data     []byte
+		expect   Exception
+	}{
+		{3, []byte{0, 0, 0, 1}, Success},
+		{3, []byte{0}, IllegalFunction},
+		{7, []byte{}, IllegalFunction},
+		{8, []byte{0, 0, 0xa5, 0x37}, IllegalFunction},
+		{17, []byte{}, IllegalFunction},
+		{20, []byte{7, 6, 0, 4, 0, 0, 0, 1}, IllegalFunction},
+		{21, []byte{9, 6, 0, 4, 0, 0, 0, 1, 0, 1}, IllegalFunction},
+		{24, []byte{0, 0}, IllegalFunction},
+		{43, []byte{0x0e, 1, 0}, IllegalFunction},
+	}
+	for _, test := range tests {
+		frame := &TCPFrame{Device: 1, Function: test.function}
+		frame.SetData(test.data)
+		response := s.handle(&Request{frame: frame, role: "Limited"})
+		exception := GetException(response)
+		if exception != test.expect {
+			t.Errorf("function %v: expected %v, got %v", test.function, test.expect.String(), exception.String())
+		}
+	}
+	if values, _ := slave.FileRecords(4, 0, 2); !isEqual([]uint16{0, 0}, values) {
+		t.Errorf("expected file records to be unchanged, got %v", values)
+	}
+}
+
+func TestModbusTLSRole(t *testing.T) {
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.SetAuthorizationPolicy(AuthorizationPolicy{
+		"ReadOnly": {Functions: []uint8{1, 2, 3, 4}},
+	})
+	roles := make(chan string, 1)
+	s.RegisterHandler(3, HandlerFunc(func(ctx context.Context, request *Request) ([]byte, *Exception) {
+		roles <- request.Role()
+		return []byte{2, 0, 0}, &Success
+	}))
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, "")},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	err := s.ListenTLS("127.0.0.1:3336", config)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := tls.Dial("tcp", "127.0.0.1:3336", &tls.Config{
+		Certificates:       []tls.Certificate{testCertificate(t, "ReadOnly")},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 6}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	conn.Write(frame.Bytes())
+
+	response := make([]byte, 512)
+	bytesRead, err := conn.Read(response)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	expect := []byte{0, 1, 0, 0, 0, 3, 1, 0x86, byte(IllegalFunction)}
+	got := response[:bytesRead]
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// Handlers receive the role of the client.
+	frame.Function = 3
+	conn.Write(frame.Bytes())
+	_, err = conn.Read(response)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if role := <-roles; role != "ReadOnly" {
+		t.Errorf("expected ReadOnly, got %v", role)
+	}
+}
@@ -189,7 +189,10 @@ func ExampleServer_RegisterFunctionHandler() {
 	// Override ReadDiscreteInputs function.
 	serv.RegisterFunctionHandler(2,
 		func(s *Server, frame Framer) ([]byte, *Exception) {
-			register, numRegs, endRegister := registerAddressAndNumber(frame)
+			register, numRegs, endRegister, exception := registerAddressAndNumber(frame)
+			if exception != &Success {
+				return []byte{}, exception
+			}
 			// Check the request is within the allocated memory
 			if endRegister > 65535 {
 				return []byte{}, &IllegalDataAddress
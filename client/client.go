@@ -0,0 +1,357 @@
+// Package client implements a Modbus client (master) sharing the frame codecs
+// of the mbserver package.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/elcdrue/mbserver"
+)
+
+// DefaultTimeout is the time waited for a response when Client.Timeout is not
+// set.
+const DefaultTimeout = time.Second
+
+// ErrTimeout is returned when no response is received after all retries.
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "modbus: response timeout" }
+
+// Timeout reports the error is a timeout, as net.Error does.
+func (timeoutError) Timeout() bool { return true }
+
+// ErrClosed is returned once the client is closed.
+var ErrClosed = errors.New("modbus: client closed")
+
+// ExceptionError is returned when the server answers a request with an
+// exception.
+type ExceptionError struct {
+	Function  uint8
+	Exception mbserver.Exception
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus: function %d: exception %d (%v)", e.Function, uint8(e.Exception), e.Exception)
+}
+
+// framing encodes requests and decodes responses of a transport.
+type framing interface {
+	// request returns an empty request frame.
+	request(unitID byte, function uint8) mbserver.Framer
+	// prepare readies a request before each attempt to send it.
+	prepare(request mbserver.Framer)
+	// responseLength returns the length of the response at the start of the
+	// packet, 0 if more bytes are needed to tell and -1 if the response ends
+	// with the frame silence.
+	responseLength(packet []byte) (int, error)
+	// response decodes a response, the packet is not retained.
+	response(packet []byte) (mbserver.Framer, error)
+	// matches reports whether a response answers the request.
+	matches(request, response mbserver.Framer) bool
+}
+
+// Client is a Modbus client. Requests are sent one at a time, a Client may be
+// used by several goroutines.
+type Client struct {
+	// UnitID addressed by requests.
+	UnitID byte
+	// Timeout waiting for the response to each attempt, DefaultTimeout if 0.
+	Timeout time.Duration
+	// Retries of a request which response timed out.
+	Retries int
+
+	conn         io.ReadWriteCloser
+	framing      framing
+	frameSilence time.Duration
+	mutex        sync.Mutex
+	responses    chan mbserver.Framer
+	reset        chan struct{}
+	closed       chan struct{}
+	closeOnce    sync.Once
+	done         chan struct{}
+	err          error // set before done is closed
+}
+
+// NewTCPClient returns a Modbus TCP client sending requests over a connection.
+func NewTCPClient(conn io.ReadWriteCloser) *Client {
+	return newClient(conn, &tcpFraming{}, 0)
+}
+
+// NewRTUClient returns a Modbus RTU client sending requests over a serial port
+// or any other stream. The options give the frame silence ending responses
+// which length is not known from their function code.
+func NewRTUClient(conn io.ReadWriteCloser, opts mbserver.RTUOptions) *Client {
+	return newClient(conn, &rtuFraming{}, opts.Silence())
+}
+
+// DialTCP connects to a Modbus TCP server.
+func DialTCP(address string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewTCPClient(conn), nil
+}
+
+func newClient(conn io.ReadWriteCloser, framing framing, frameSilence time.Duration) *Client {
+	c := &Client{
+		UnitID:       1,
+		conn:         conn,
+		framing:      framing,
+		frameSilence: frameSilence,
+		responses:    make(chan mbserver.Framer),
+		reset:        make(chan struct{}),
+		closed:       make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go c.receive()
+	return c
+}
+
+// Close closes the connection of the client.
+func (c *Client) Close() error {
+	err := ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// Send sends a request of any function with the given data and returns the
+// data of the response.
+func (c *Client) Send(function uint8, data []byte) ([]byte, error) {
+	request := c.request(function)
+	request.SetData(data)
+	return c.send(request, true)
+}
+
+func (c *Client) request(function uint8) mbserver.Framer {
+	return c.framing.request(c.UnitID, function)
+}
+
+// Forward sends a request received by a server, keeping its unit ID, function
+// and data, and returns the response, exception responses included. It makes
+// the client the gateway of a server, see mbserver.Server.SetGateway.
+func (c *Client) Forward(request mbserver.Framer) (mbserver.Framer, error) {
+	return c.ForwardTimeout(request, 0)
+}
+
+// ForwardTimeout is Forward waiting for the response to each attempt for the
+// given timeout instead of the client's.
+func (c *Client) ForwardTimeout(request mbserver.Framer, timeout time.Duration) (mbserver.Framer, error) {
+	forward := c.framing.request(request.GetAddress(), request.GetFunction())
+	forward.SetData(request.GetData())
+	return c.exchange(forward, true, timeout)
+}
+
+// send exchanges a request and returns the data of the response, or the
+// exception it holds as an ExceptionError.
+func (c *Client) send(request mbserver.Framer, expectResponse bool) ([]byte, error) {
+	response, err := c.exchange(request, expectResponse, 0)
+	if err != nil || response == nil {
+		return nil, err
+	}
+	if exception := mbserver.GetException(response); exception != mbserver.Success {
+		return nil, &ExceptionError{Function: request.GetFunction(), Exception: exception}
+	}
+	return response.GetData(), nil
+}
+
+// exchange writes a request and waits for the matching response, retrying on
+// timeout. Responses to earlier requests received late are discarded. The
+// client's timeout applies if timeout is 0.
+func (c *Client) exchange(request mbserver.Framer, expectResponse bool, timeout time.Duration) (mbserver.Framer, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Broadcast requests on a serial line are never answered; over TCP, unit
+	// ID 0 commonly addresses the server itself.
+	if _, isRTU := request.(*mbserver.RTUFrame); isRTU && request.GetAddress() == 0 {
+		expectResponse = false
+	}
+	if timeout <= 0 {
+		timeout = c.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		c.framing.prepare(request)
+		if !c.discard() {
+			return nil, c.err
+		}
+		_, err := c.conn.Write(request.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if !expectResponse {
+			return nil, nil
+		}
+
+		response, err := c.wait(request, timeout)
+		if err == ErrTimeout {
+			continue
+		}
+		return response, err
+	}
+	return nil, ErrTimeout
+}
+
+// discard drops the responses to earlier requests received late and, on a
+// serial line, the bytes of a response being received. It returns false once
+// the client is done.
+func (c *Client) discard() bool {
+	for {
+		select {
+		case <-c.responses:
+		case c.reset <- struct{}{}:
+			return true
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+// wait returns the response matching the request.
+func (c *Client) wait(request mbserver.Framer, timeout time.Duration) (mbserver.Framer, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case response := <-c.responses:
+			if c.framing.matches(request, response) {
+				return response, nil
+			}
+		case <-c.done:
+			return nil, c.err
+		case <-timer.C:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// receive decodes the responses read from the connection until it fails or
+// the client is closed.
+func (c *Client) receive() {
+	defer close(c.done)
+
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	go c.read(chunks, readErr)
+
+	// The silence timer runs while an RTU response is received, it ends
+	// responses of unknown length and discards incomplete ones.
+	silence := time.NewTimer(time.Hour)
+	silence.Stop()
+	defer silence.Stop()
+	var packet []byte
+
+	for {
+		select {
+		case <-c.closed:
+			c.err = ErrClosed
+			return
+
+		case err := <-readErr:
+			c.err = err
+			select {
+			case <-c.closed:
+				c.err = ErrClosed
+			default:
+			}
+			return
+
+		case chunk := <-chunks:
+			packet = append(packet, chunk...)
+			for len(packet) > 0 {
+				length, err := c.framing.responseLength(packet)
+				if err != nil {
+					// The frame boundaries are lost.
+					c.err = err
+					c.conn.Close()
+					return
+				}
+				if length <= 0 || length > len(packet) {
+					break
+				}
+				c.deliver(packet[:length])
+				packet = packet[length:]
+			}
+			if c.frameSilence > 0 {
+				if !silence.Stop() {
+					select {
+					case <-silence.C:
+					default:
+					}
+				}
+				if len(packet) > 0 {
+					silence.Reset(c.frameSilence)
+				}
+			}
+
+		case <-silence.C:
+			if length, _ := c.framing.responseLength(packet); length < 0 {
+				c.deliver(packet)
+			}
+			packet = nil
+
+		case <-c.reset:
+			// RTU responses are matched by address and function only, a
+			// late response must not be taken for the next one. TCP
+			// responses are matched by transaction ID and the stream must
+			// keep its framing.
+			if c.frameSilence > 0 {
+				if !silence.Stop() {
+					select {
+					case <-silence.C:
+					default:
+					}
+				}
+				packet = nil
+			}
+		}
+	}
+}
+
+// deliver passes a decoded response to the request waiting for it. Invalid
+// responses are dropped, their request times out.
+func (c *Client) deliver(packet []byte) {
+	response, err := c.framing.response(packet)
+	if err != nil {
+		return
+	}
+	select {
+	case c.responses <- response:
+	case <-c.closed:
+	}
+}
+
+// read passes the bytes read from the connection to receive.
+func (c *Client) read(chunks chan<- []byte, readErr chan<- error) {
+	buffer := make([]byte, 512)
+	for {
+		bytesRead, err := c.conn.Read(buffer)
+		if bytesRead > 0 {
+			chunk := make([]byte, bytesRead)
+			copy(chunk, buffer)
+			select {
+			case chunks <- chunk:
+			case <-c.done:
+				return
+			}
+		}
+		if err != nil {
+			readErr <- err
+			return
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package client
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/elcdrue/mbserver"
+)
+
+func isEqual(a interface{}, b interface{}) bool {
+	expect, _ := json.Marshal(a)
+	got, _ := json.Marshal(b)
+	if string(expect) != string(got) {
+		return false
+	}
+	return true
+}
+
+// rtuOptions are the options of in-process RTU streams, which have no
+// character timing.
+var rtuOptions = mbserver.RTUOptions{FrameSilence: 20 * time.Millisecond}
+
+// testClients returns a TCP and an RTU client of a server.
+func testClients(t *testing.T, s *mbserver.Server) map[string]*Client {
+	err := s.ListenTCP("127.0.0.1:3338")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	tcp, err := DialTCP("127.0.0.1:3338", time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+
+	server, conn := net.Pipe()
+	go s.ServeRTU(server, rtuOptions)
+	rtu := NewRTUClient(conn, rtuOptions)
+
+	return map[string]*Client{"TCP": tcp, "RTU": rtu}
+}
+
+func TestClientFunctions(t *testing.T) {
+	s := mbserver.NewServer(1, 1, 30000, 30000)
+	defer s.Close()
+	clients := testClients(t, s)
+
+	slave, _ := s.Slave(1)
+	slave.SetFileRecords(4, 0, 0, 0, 0)
+	slave.PushFIFO(1000, 0x1234)
+	slave.SetDeviceIdentification(mbserver.DeviceIDVendorName, "vendor")
+	slave.SetDeviceIdentification(mbserver.DeviceIDProductCode, "product")
+	slave.SetDeviceIdentification(mbserver.DeviceIDMajorMinorRevision, "1.0")
+
+	for name, c := range clients {
+		defer c.Close()
+
+		err := c.WriteMultipleCoils(100, []byte{1, 0, 1, 1, 0, 0, 0, 0, 1})
+		if err != nil {
+			t.Fatalf("%s: expected nil, got %v\n", name, err)
+		}
+		err = c.WriteSingleCoil(101, true)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		coils, err := c.ReadCoils(100, 9)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		expect := []byte{1, 1, 1, 1, 0, 0, 0, 0, 1}
+		if !isEqual(expect, coils) {
+			t.Errorf("%s: expected %v, got %v", name, expect, coils)
+		}
+
+		err = c.WriteMultipleRegisters(200, []uint16{1, 2, 3})
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		err = c.WriteSingleRegister(201, 0xff00)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		err = c.MaskWriteRegister(202, 0xfff0, 0x0008)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		registers, err := c.ReadHoldingRegisters(200, 3)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		expectRegs := []uint16{1, 0xff00, 0x0008}
+		if !isEqual(expectRegs, registers) {
+			t.Errorf("%s: expected %v, got %v", name, expectRegs, registers)
+		}
+
+		registers, err = c.ReadWriteMultipleRegisters(200, 2, 201, []uint16{7})
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		expectRegs = []uint16{1, 7}
+		if !isEqual(expectRegs, registers) {
+			t.Errorf("%s: expected %v, got %v", name, expectRegs, registers)
+		}
+
+		status, err := c.ReadExceptionStatus()
+		if err != nil || status != 0 {
+			t.Errorf("%s: expected 0 <nil>, got %v %v\n", name, status, err)
+		}
+
+		serverID, err := c.ReportServerID()
+		if err != nil || len(serverID) < 2 {
+			t.Errorf("%s: expected server ID, got %v %v\n", name, serverID, err)
+		}
+
+		err = c.WriteFileRecord(4, 1, []uint16{0x0102, 0x0304})
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		records, err := c.ReadFileRecord(4, 1, 2)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		expectRegs = []uint16{0x0102, 0x0304}
+		if !isEqual(expectRegs, records) {
+			t.Errorf("%s: expected %v, got %v", name, expectRegs, records)
+		}
+
+		fifo, err := c.ReadFIFOQueue(1000)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v\n", name, err)
+		}
+		expectRegs = []uint16{0x1234}
+		if !isEqual(expectRegs, fifo) {
+			t.Errorf("%s: expected %v, got %v", name, expectRegs, fifo)
+		}
+
+		objects, err := c.ReadDeviceIdentification(ReadDeviceIDBasic)
+		if err != nil || len(objects) != 3 || objects[mbserver.DeviceIDProductCode] != "product" {
+			t.Errorf("%s: expected basic objects, got %v %v\n", name, objects, err)
+		}
+		value, err := c.ReadDeviceIdentificationObject(mbserver.DeviceIDVendorName)
+		if err != nil || value != "vendor" {
+			t.Errorf("%s: expected vendor, got %v %v\n", name, value, err)
+		}
+	}
+}
+
+func TestClientSerialLineFunctions(t *testing.T) {
+	s := mbserver.NewServer(1, 1, 0, 0)
+	defer s.Close()
+	c := testClients(t, s)["RTU"]
+	defer c.Close()
+
+	data, err := c.Diagnostics(0, []byte{0xa5, 0x37})
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+	expect := []byte{0xa5, 0x37}
+	if !isEqual(expect, data) {
+		t.Errorf("expected %v, got %v", expect, data)
+	}
+
+	status, count, err := c.GetCommEventCounter()
+	if err != nil || status != 0 || count != 1 {
+		t.Errorf("expected 0 1 <nil>, got %v %v %v\n", status, count, err)
+	}
+
+	log, err := c.GetCommEventLog()
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if log.EventCount != 1 || log.MessageCount != 3 || len(log.Events) != 5 {
+		t.Errorf("expected 1 event 3 messages 5 events, got %+v", log)
+	}
+}
+
+func TestClientException(t *testing.T) {
+	s := mbserver.NewServer(1, 1, 0, 0)
+	defer s.Close()
+
+	for name, c := range testClients(t, s) {
+		defer c.Close()
+
+		_, err := c.ReadHoldingRegisters(65535, 2)
+		exception, ok := err.(*ExceptionError)
+		if !ok || exception.Function != 3 || exception.Exception != mbserver.IllegalDataAddress {
+			t.Errorf("%s: expected IllegalDataAddress, got %v\n", name, err)
+		}
+
+		_, err = c.Send(99, nil)
+		exception, ok = err.(*ExceptionError)
+		if !ok || exception.Exception != mbserver.IllegalFunction {
+			t.Errorf("%s: expected IllegalFunction, got %v\n", name, err)
+		}
+	}
+}
+
+// readTCPRequest reads a request from a fake server.
+func readTCPRequest(t *testing.T, conn net.Conn) *mbserver.TCPFrame {
+	header := make([]byte, 6)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[4:6]))
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	frame, err := mbserver.NewTCPFrame(append(header, body...))
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	return frame
+}
+
+func TestClientRetryAndTransactionID(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	c := NewTCPClient(conn)
+	defer c.Close()
+	c.Timeout = 50 * time.Millisecond
+	c.Retries = 1
+
+	go func() {
+		// The first attempt is not answered, the second is answered late
+		// to the first attempt and then to the second.
+		first := readTCPRequest(t, server)
+		second := readTCPRequest(t, server)
+		if first.TransactionIdentifier == second.TransactionIdentifier {
+			t.Errorf("expected new transaction ID, got %v", second.TransactionIdentifier)
+		}
+		for _, request := range []*mbserver.TCPFrame{first, second} {
+			response := request.Copy()
+			response.SetData([]byte{2, 0, byte(request.TransactionIdentifier)})
+			server.Write(response.Bytes())
+		}
+	}()
+
+	registers, err := c.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	expect := []uint16{2}
+	if !isEqual(expect, registers) {
+		t.Errorf("expected %v, got %v", expect, registers)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	c := NewRTUClient(conn, rtuOptions)
+	defer c.Close()
+	c.Timeout = 20 * time.Millisecond
+	c.Retries = 2
+
+	requests := make(chan []byte, 3)
+	go func() {
+		buffer := make([]byte, 256)
+		for {
+			n, err := server.Read(buffer)
+			if err != nil {
+				close(requests)
+				return
+			}
+			requests <- append([]byte(nil), buffer[:n]...)
+		}
+	}()
+
+	_, err := c.ReadCoils(0, 1)
+	if err != ErrTimeout {
+		t.Errorf("expected %v, got %v", ErrTimeout, err)
+	}
+	c.Close()
+	count := 0
+	for range requests {
+		count++
+	}
+	if count != 3 {
+		t.Errorf("expected 3 attempts, got %v", count)
+	}
+}
+
+func TestClientLateResponse(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	c := NewRTUClient(conn, rtuOptions)
+	defer c.Close()
+	c.Timeout = 50 * time.Millisecond
+
+	// The device answers the first request late, then each request with
+	// its number.
+	go func() {
+		buffer := make([]byte, 256)
+		for value := byte(1); ; value++ {
+			_, err := server.Read(buffer)
+			if err != nil {
+				return
+			}
+			if value == 1 {
+				time.Sleep(80 * time.Millisecond)
+			}
+			response := mbserver.RTUFrame{Address: 1, Function: 3, Data: []byte{2, 0, value}}
+			server.Write(response.Bytes())
+		}
+	}()
+
+	_, err := c.ReadHoldingRegisters(0, 1)
+	if err != ErrTimeout {
+		t.Errorf("expected %v, got %v", ErrTimeout, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for _, value := range []uint16{2, 3} {
+		registers, err := c.ReadHoldingRegisters(0, 1)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		expect := []uint16{value}
+		if !isEqual(expect, registers) {
+			t.Errorf("expected %v, got %v", expect, registers)
+		}
+	}
+}
+
+func TestClientBroadcast(t *testing.T) {
+	s := mbserver.NewServer(1, 2, 0, 0)
+	defer s.Close()
+	c := testClients(t, s)["RTU"]
+	defer c.Close()
+
+	c.UnitID = 0
+	err := c.WriteSingleRegister(10, 42)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+
+	// The broadcast is executed once the following request is answered.
+	c.UnitID = 2
+	registers, err := c.ReadHoldingRegisters(10, 1)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+	expect := []uint16{42}
+	if !isEqual(expect, registers) {
+		t.Errorf("expected %v, got %v", expect, registers)
+	}
+}
@@ -0,0 +1,376 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/elcdrue/mbserver"
+)
+
+// Read device ID codes of ReadDeviceIdentification.
+const (
+	ReadDeviceIDBasic    byte = 0x01
+	ReadDeviceIDRegular  byte = 0x02
+	ReadDeviceIDExtended byte = 0x03
+)
+
+// diagForceListenOnlyMode is the Diagnostics sub-function never answered.
+const diagForceListenOnlyMode = 0x04
+
+// EventLog is the response to GetCommEventLog.
+type EventLog struct {
+	Status       uint16
+	EventCount   uint16
+	MessageCount uint16
+	// Events, the most recent first.
+	Events []byte
+}
+
+func responseError(function uint8, data []byte) error {
+	return fmt.Errorf("modbus: function %d: malformed response %v", function, data)
+}
+
+// ReadCoils function 1, reads coils. Each coil is returned as a byte 0 or 1,
+// as held in mbserver.SlaveMemory.
+func (c *Client) ReadCoils(address uint16, quantity uint16) ([]byte, error) {
+	return c.readBits(1, address, quantity)
+}
+
+// ReadDiscreteInputs function 2, reads discrete inputs. Each input is returned
+// as a byte 0 or 1.
+func (c *Client) ReadDiscreteInputs(address uint16, quantity uint16) ([]byte, error) {
+	return c.readBits(2, address, quantity)
+}
+
+func (c *Client) readBits(function uint8, address uint16, quantity uint16) ([]byte, error) {
+	request := c.request(function)
+	mbserver.SetDataWithRegisterAndNumber(request, address, quantity)
+	data, err := c.send(request, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 || int(data[0]) != len(data)-1 || int(data[0]) != (int(quantity)+7)/8 {
+		return nil, responseError(function, data)
+	}
+
+	bits := make([]byte, quantity)
+	for i := range bits {
+		bits[i] = (data[1+i/8] >> uint(i%8)) & 1
+	}
+	return bits, nil
+}
+
+// ReadHoldingRegisters function 3, reads holding registers.
+func (c *Client) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(3, address, quantity)
+}
+
+// ReadInputRegisters function 4, reads input registers.
+func (c *Client) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(4, address, quantity)
+}
+
+func (c *Client) readRegisters(function uint8, address uint16, quantity uint16) ([]uint16, error) {
+	request := c.request(function)
+	mbserver.SetDataWithRegisterAndNumber(request, address, quantity)
+	data, err := c.send(request, true)
+	if err != nil {
+		return nil, err
+	}
+	return registersResponse(function, data, int(quantity))
+}
+
+// registersResponse decodes the registers following a byte count.
+func registersResponse(function uint8, data []byte, quantity int) ([]uint16, error) {
+	if len(data) < 1 || int(data[0]) != len(data)-1 || int(data[0]) != quantity*2 {
+		return nil, responseError(function, data)
+	}
+	return mbserver.BytesToUint16(data[1:]), nil
+}
+
+// WriteSingleCoil function 5, sets a coil on or off.
+func (c *Client) WriteSingleCoil(address uint16, value bool) error {
+	var state uint16
+	if value {
+		state = 0xff00
+	}
+	request := c.request(5)
+	mbserver.SetDataWithRegisterAndNumber(request, address, state)
+	return c.sendEcho(request)
+}
+
+// WriteSingleRegister function 6, writes a holding register.
+func (c *Client) WriteSingleRegister(address uint16, value uint16) error {
+	request := c.request(6)
+	mbserver.SetDataWithRegisterAndNumber(request, address, value)
+	return c.sendEcho(request)
+}
+
+// sendEcho sends a request answered by an echo of its data.
+func (c *Client) sendEcho(request mbserver.Framer) error {
+	expect := request.GetData()
+	data, err := c.send(request, true)
+	if err != nil || data == nil {
+		return err
+	}
+	if string(data) != string(expect) {
+		return responseError(request.GetFunction(), data)
+	}
+	return nil
+}
+
+// ReadExceptionStatus function 7, reads the exception status byte.
+func (c *Client) ReadExceptionStatus() (byte, error) {
+	data, err := c.send(c.request(7), true)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 1 {
+		return 0, responseError(7, data)
+	}
+	return data[0], nil
+}
+
+// Diagnostics function 8, sends a diagnostics sub-function with its data and
+// returns the data of the response following the sub-function. Force Listen
+// Only Mode (sub-function 4) is never answered and returns nil.
+func (c *Client) Diagnostics(subFunction uint16, data []byte) ([]byte, error) {
+	request := c.request(8)
+	requestData := make([]byte, 2, 2+len(data))
+	binary.BigEndian.PutUint16(requestData, subFunction)
+	request.SetData(append(requestData, data...))
+
+	response, err := c.send(request, subFunction != diagForceListenOnlyMode)
+	if err != nil || response == nil {
+		return nil, err
+	}
+	if len(response) < 2 || binary.BigEndian.Uint16(response[0:2]) != subFunction {
+		return nil, responseError(8, response)
+	}
+	return response[2:], nil
+}
+
+// GetCommEventCounter function 11, reads the status word and event counter.
+func (c *Client) GetCommEventCounter() (status uint16, eventCount uint16, err error) {
+	data, err := c.send(c.request(11), true)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(data) != 4 {
+		return 0, 0, responseError(11, data)
+	}
+	return binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4]), nil
+}
+
+// GetCommEventLog function 12, reads the status word, event and message
+// counters and the event log.
+func (c *Client) GetCommEventLog() (*EventLog, error) {
+	data, err := c.send(c.request(12), true)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 7 || int(data[0]) != len(data)-1 {
+		return nil, responseError(12, data)
+	}
+	return &EventLog{
+		Status:       binary.BigEndian.Uint16(data[1:3]),
+		EventCount:   binary.BigEndian.Uint16(data[3:5]),
+		MessageCount: binary.BigEndian.Uint16(data[5:7]),
+		Events:       data[7:],
+	}, nil
+}
+
+// WriteMultipleCoils function 15, writes coils. Each coil is given as a byte,
+// on if not 0.
+func (c *Client) WriteMultipleCoils(address uint16, values []byte) error {
+	bytes := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value != 0 {
+			bytes[i/8] |= 1 << uint(i%8)
+		}
+	}
+	request := c.request(15)
+	mbserver.SetDataWithRegisterAndNumberAndBytes(request, address, uint16(len(values)), bytes)
+	return c.sendWriteMultiple(request)
+}
+
+// WriteMultipleRegisters function 16, writes holding registers.
+func (c *Client) WriteMultipleRegisters(address uint16, values []uint16) error {
+	request := c.request(16)
+	mbserver.SetDataWithRegisterAndNumberAndValues(request, address, uint16(len(values)), values)
+	return c.sendWriteMultiple(request)
+}
+
+// sendWriteMultiple sends a request answered by its address and quantity.
+func (c *Client) sendWriteMultiple(request mbserver.Framer) error {
+	expect := request.GetData()[0:4]
+	data, err := c.send(request, true)
+	if err != nil || data == nil {
+		return err
+	}
+	if string(data) != string(expect) {
+		return responseError(request.GetFunction(), data)
+	}
+	return nil
+}
+
+// ReportServerID function 17, reads the server ID, run indicator status and
+// additional data, as returned by the server.
+func (c *Client) ReportServerID() ([]byte, error) {
+	data, err := c.send(c.request(17), true)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 || int(data[0]) != len(data)-1 {
+		return nil, responseError(17, data)
+	}
+	return data[1:], nil
+}
+
+// ReadFileRecord function 20, reads length records of a file starting at a
+// record number.
+func (c *Client) ReadFileRecord(file uint16, record uint16, length uint16) ([]uint16, error) {
+	request := c.request(20)
+	data := make([]byte, 8)
+	data[0] = 7
+	data[1] = 6 // reference type
+	binary.BigEndian.PutUint16(data[2:4], file)
+	binary.BigEndian.PutUint16(data[4:6], record)
+	binary.BigEndian.PutUint16(data[6:8], length)
+	request.SetData(data)
+
+	response, err := c.send(request, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) != 3+int(length)*2 || int(response[0]) != len(response)-1 ||
+		int(response[1]) != len(response)-2 || response[2] != 6 {
+		return nil, responseError(20, response)
+	}
+	return mbserver.BytesToUint16(response[3:]), nil
+}
+
+// WriteFileRecord function 21, writes records of a file starting at a record
+// number.
+func (c *Client) WriteFileRecord(file uint16, record uint16, values []uint16) error {
+	request := c.request(21)
+	data := make([]byte, 8, 8+len(values)*2)
+	data[0] = byte(7 + len(values)*2)
+	data[1] = 6 // reference type
+	binary.BigEndian.PutUint16(data[2:4], file)
+	binary.BigEndian.PutUint16(data[4:6], record)
+	binary.BigEndian.PutUint16(data[6:8], uint16(len(values)))
+	request.SetData(append(data, mbserver.Uint16ToBytes(values)...))
+	return c.sendEcho(request)
+}
+
+// MaskWriteRegister function 22, modifies a holding register with an AND mask
+// and an OR mask.
+func (c *Client) MaskWriteRegister(address uint16, andMask uint16, orMask uint16) error {
+	request := c.request(22)
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[0:2], address)
+	binary.BigEndian.PutUint16(data[2:4], andMask)
+	binary.BigEndian.PutUint16(data[4:6], orMask)
+	request.SetData(data)
+	return c.sendEcho(request)
+}
+
+// ReadWriteMultipleRegisters function 23, writes holding registers and then
+// reads holding registers in a single transaction.
+func (c *Client) ReadWriteMultipleRegisters(readAddress uint16, readQuantity uint16, writeAddress uint16, values []uint16) ([]uint16, error) {
+	request := c.request(23)
+	data := make([]byte, 4, 9+len(values)*2)
+	binary.BigEndian.PutUint16(data[0:2], readAddress)
+	binary.BigEndian.PutUint16(data[2:4], readQuantity)
+	// The write part has the layout of a write multiple registers request.
+	write := &mbserver.TCPFrame{}
+	mbserver.SetDataWithRegisterAndNumberAndValues(write, writeAddress, uint16(len(values)), values)
+	request.SetData(append(data, write.Data...))
+
+	response, err := c.send(request, true)
+	if err != nil {
+		return nil, err
+	}
+	return registersResponse(23, response, int(readQuantity))
+}
+
+// ReadFIFOQueue function 24, reads the registers of a FIFO queue.
+func (c *Client) ReadFIFOQueue(address uint16) ([]uint16, error) {
+	request := c.request(24)
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, address)
+	request.SetData(data)
+
+	response, err := c.send(request, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 4 || int(binary.BigEndian.Uint16(response[0:2])) != len(response)-2 ||
+		int(binary.BigEndian.Uint16(response[2:4]))*2 != len(response)-4 {
+		return nil, responseError(24, response)
+	}
+	return mbserver.BytesToUint16(response[4:]), nil
+}
+
+// ReadDeviceIdentification function 43 MEI type 14, reads the device
+// identification objects of a category using stream access. Objects not
+// fitting in one response are read by following transactions.
+func (c *Client) ReadDeviceIdentification(code byte) (map[byte]string, error) {
+	objects := make(map[byte]string)
+	objectID := byte(0)
+	for {
+		moreFollows, nextObjectID, err := c.readDeviceID(code, objectID, objects)
+		if err != nil {
+			return nil, err
+		}
+		if !moreFollows {
+			return objects, nil
+		}
+		if nextObjectID <= objectID {
+			return nil, fmt.Errorf("modbus: function 43: next object ID %d does not progress", nextObjectID)
+		}
+		objectID = nextObjectID
+	}
+}
+
+// ReadDeviceIdentificationObject function 43 MEI type 14, reads one device
+// identification object using individual access.
+func (c *Client) ReadDeviceIdentificationObject(objectID byte) (string, error) {
+	objects := make(map[byte]string)
+	_, _, err := c.readDeviceID(0x04, objectID, objects)
+	if err != nil {
+		return "", err
+	}
+	value, ok := objects[objectID]
+	if !ok {
+		return "", fmt.Errorf("modbus: function 43: object %d not returned", objectID)
+	}
+	return value, nil
+}
+
+// readDeviceID sends a read device identification request and adds the
+// objects of the response.
+func (c *Client) readDeviceID(code byte, objectID byte, objects map[byte]string) (moreFollows bool, nextObjectID byte, err error) {
+	request := c.request(43)
+	request.SetData([]byte{0x0e, code, objectID})
+	data, err := c.send(request, true)
+	if err != nil {
+		return false, 0, err
+	}
+
+	// MEI type, read device ID code, conformity level, more follows, next
+	// object ID and number of objects.
+	if len(data) < 6 || data[0] != 0x0e || data[1] != code {
+		return false, 0, responseError(43, data)
+	}
+	i := 6
+	for n := 0; n < int(data[5]); n++ {
+		if len(data) < i+2 || len(data) < i+2+int(data[i+1]) {
+			return false, 0, responseError(43, data)
+		}
+		objects[data[i]] = string(data[i+2 : i+2+int(data[i+1])])
+		i += 2 + int(data[i+1])
+	}
+	return data[3] == 0xff, data[4], nil
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/elcdrue/mbserver"
+)
+
+func TestGateway(t *testing.T) {
+	// Device 5 on an RS-485 bus behind a gateway hosting unit 1.
+	device := mbserver.NewServer(5, 5, 0, 0)
+	defer device.Close()
+	slave, _ := device.Slave(5)
+	slave.HoldingRegisters[10] = 1234
+
+	busServer, busConn := net.Pipe()
+	go device.ServeRTU(busServer, rtuOptions)
+	bus := NewRTUClient(busConn, rtuOptions)
+	bus.Timeout = 100 * time.Millisecond
+
+	gateway := mbserver.NewServer(1, 1, 0, 0)
+	defer gateway.Close()
+	gateway.SetGateway(bus)
+	err := gateway.ListenTCP("127.0.0.1:3339")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	c, err := DialTCP("127.0.0.1:3339", time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer c.Close()
+
+	c.UnitID = 5
+	registers, err := c.ReadHoldingRegisters(10, 1)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+	expect := []uint16{1234}
+	if !isEqual(expect, registers) {
+		t.Errorf("expected %v, got %v", expect, registers)
+	}
+
+	_, err = c.ReadHoldingRegisters(65535, 2)
+	if e, ok := err.(*ExceptionError); !ok || e.Exception != mbserver.IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", err)
+	}
+
+	c.UnitID = 6
+	_, err = c.ReadHoldingRegisters(10, 1)
+	if e, ok := err.(*ExceptionError); !ok || e.Exception != mbserver.GatewayTargetDeviceFailedtoRespond {
+		t.Errorf("expected GatewayTargetDeviceFailedtoRespond, got %v", err)
+	}
+
+	bus.Close()
+	_, err = c.ReadHoldingRegisters(10, 1)
+	if e, ok := err.(*ExceptionError); !ok || e.Exception != mbserver.GatewayPathUnavailable {
+		t.Errorf("expected GatewayPathUnavailable, got %v", err)
+	}
+}
+
+func TestReverseGateway(t *testing.T) {
+	// A Modbus TCP device, unit 1, reached by an RTU master as unit 7.
+	device := mbserver.NewServer(1, 1, 0, 0)
+	defer device.Close()
+	slave, _ := device.Slave(1)
+	slave.HoldingRegisters[10] = 4321
+	err := device.ListenTCP("127.0.0.1:3340")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	upstream, err := DialTCP("127.0.0.1:3340", time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer upstream.Close()
+
+	gateway := mbserver.NewServer(1, 1, 0, 0)
+	defer gateway.Close()
+	gateway.SetSerialRoute(7, &mbserver.SerialRoute{Forwarder: upstream, UnitID: 1})
+	serialServer, serialConn := net.Pipe()
+	go gateway.ServeRTU(serialServer, rtuOptions)
+	c := NewRTUClient(serialConn, rtuOptions)
+	defer c.Close()
+
+	c.UnitID = 7
+	registers, err := c.ReadHoldingRegisters(10, 1)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+	expect := []uint16{4321}
+	if !isEqual(expect, registers) {
+		t.Errorf("expected %v, got %v", expect, registers)
+	}
+
+	upstream.Close()
+	_, err = c.ReadHoldingRegisters(10, 1)
+	if e, ok := err.(*ExceptionError); !ok || e.Exception != mbserver.GatewayPathUnavailable {
+		t.Errorf("expected GatewayPathUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elcdrue/mbserver"
+)
+
+// DefaultPoolSize is the number of connections of a Pool when Size is not set.
+const DefaultPoolSize = 4
+
+// Pool is a pool of connections to a Modbus TCP server forwarding requests,
+// up to Size concurrently. Connections are dialed when needed and dropped when
+// they fail. Each connection numbers its requests with its own transaction
+// IDs, the responses keep the transaction ID of the forwarded request. A Pool
+// is the upstream of an mbserver.Route.
+type Pool struct {
+	Address string
+	// Size is the maximum number of connections, DefaultPoolSize if 0.
+	Size int
+	// DialTimeout, Timeout and Retries of the connections.
+	DialTimeout time.Duration
+	Timeout     time.Duration
+	Retries     int
+
+	once      sync.Once
+	semaphore chan struct{}
+	mutex     sync.Mutex
+	idle      []*Client
+	closed    bool
+}
+
+// NewPool returns a pool of connections to a Modbus TCP server.
+func NewPool(address string) *Pool {
+	return &Pool{Address: address}
+}
+
+// Forward forwards a request over a connection of the pool.
+func (p *Pool) Forward(request mbserver.Framer) (mbserver.Framer, error) {
+	return p.ForwardTimeout(request, 0)
+}
+
+// ForwardTimeout is Forward with a timeout instead of the pool's.
+func (p *Pool) ForwardTimeout(request mbserver.Framer, timeout time.Duration) (mbserver.Framer, error) {
+	p.once.Do(func() {
+		size := p.Size
+		if size <= 0 {
+			size = DefaultPoolSize
+		}
+		p.semaphore = make(chan struct{}, size)
+	})
+	p.semaphore <- struct{}{}
+	defer func() { <-p.semaphore }()
+
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.ForwardTimeout(request, timeout)
+	// Stale responses after a timeout are discarded by transaction ID, the
+	// connection is only dropped on failure.
+	if err != nil && err != ErrTimeout {
+		c.Close()
+		return nil, err
+	}
+	p.put(c)
+	return response, err
+}
+
+// get returns an idle connection or dials a new one.
+func (p *Pool) get() (*Client, error) {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		// Connections closed by the server while idle are dropped.
+		select {
+		case <-c.done:
+			c.Close()
+			continue
+		default:
+		}
+		p.mutex.Unlock()
+		return c, nil
+	}
+	p.mutex.Unlock()
+
+	dialTimeout := p.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = DefaultTimeout
+	}
+	c, err := DialTCP(p.Address, dialTimeout)
+	if err != nil {
+		// A server which cannot be reached is an unavailable path, not a
+		// device failing to respond.
+		return nil, fmt.Errorf("modbus: %v", err)
+	}
+	c.Timeout = p.Timeout
+	c.Retries = p.Retries
+	return c, nil
+}
+
+// put returns a connection to the pool.
+func (p *Pool) put(c *Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// Close closes the idle connections, connections in use are closed once their
+// request completes.
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+	return nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/elcdrue/mbserver"
+)
+
+func TestProxy(t *testing.T) {
+	// A backend PLC, unit 1, and one which never answers.
+	plc := mbserver.NewServer(1, 1, 0, 0)
+	defer plc.Close()
+	slave, _ := plc.Slave(1)
+	slave.HoldingRegisters[10] = 1111
+	err := plc.ListenTCP("127.0.0.1:3341")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	silent, err := net.Listen("tcp", "127.0.0.1:3342")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer silent.Close()
+
+	plcPool := NewPool("127.0.0.1:3341")
+	defer plcPool.Close()
+	silentPool := NewPool("127.0.0.1:3342")
+	defer silentPool.Close()
+
+	proxy := mbserver.NewServer(1, 1, 0, 0)
+	defer proxy.Close()
+	slave, _ = proxy.Slave(1)
+	slave.HoldingRegisters[10] = 2222
+	err = proxy.SetRoutes([]mbserver.Route{
+		{FirstUnitID: 1, LastUnitID: 1, Target: mbserver.RouteUpstream, Upstream: plcPool},
+		{FirstUnitID: 2, LastUnitID: 2, Target: mbserver.RouteUpstream, Upstream: silentPool, Timeout: 200 * time.Millisecond},
+		{FirstUnitID: 3, LastUnitID: 3, Target: mbserver.RouteReject},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	err = proxy.ListenTCP("127.0.0.1:3343")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	c, err := DialTCP("127.0.0.1:3343", time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer c.Close()
+
+	registers, err := c.ReadHoldingRegisters(10, 1)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+	expect := []uint16{1111}
+	if !isEqual(expect, registers) {
+		t.Errorf("expected %v, got %v", expect, registers)
+	}
+
+	c.UnitID = 3
+	_, err = c.ReadHoldingRegisters(10, 1)
+	if e, ok := err.(*ExceptionError); !ok || e.Exception != mbserver.GatewayPathUnavailable {
+		t.Errorf("expected GatewayPathUnavailable, got %v", err)
+	}
+
+	// A request to the silent PLC times out after the route timeout and
+	// does not hold up the requests of another connection.
+	slow := make(chan error, 1)
+	go func() {
+		c.UnitID = 2
+		_, err := c.ReadHoldingRegisters(10, 1)
+		slow <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	other, err := DialTCP("127.0.0.1:3343", time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer other.Close()
+	other.Timeout = 100 * time.Millisecond
+	_, err = other.ReadHoldingRegisters(10, 1)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+
+	err = <-slow
+	if e, ok := err.(*ExceptionError); !ok || e.Exception != mbserver.GatewayTargetDeviceFailedtoRespond {
+		t.Errorf("expected GatewayTargetDeviceFailedtoRespond, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"github.com/elcdrue/mbserver"
+)
+
+// rtuFraming frames requests with an address and CRC and matches the
+// responses by address and function code, as only one request is outstanding
+// on a serial line.
+type rtuFraming struct{}
+
+func (f *rtuFraming) request(unitID byte, function uint8) mbserver.Framer {
+	return &mbserver.RTUFrame{Address: unitID, Function: function}
+}
+
+// prepare leaves the request unchanged: the client discards late responses
+// before each attempt.
+func (f *rtuFraming) prepare(request mbserver.Framer) {}
+
+// responseLength derives the length of the response from its function code.
+// Diagnostics echo the request, their length is not known.
+func (f *rtuFraming) responseLength(packet []byte) (int, error) {
+	if len(packet) < 3 {
+		return 0, nil
+	}
+	if packet[1]&0x80 != 0 {
+		return 5, nil
+	}
+	// Length of a frame holding a byte count at index 2 followed by the data.
+	byteCount := 5 + int(packet[2])
+
+	switch packet[1] {
+	case 1, 2, 3, 4, 12, 17, 20, 21, 23:
+		return byteCount, nil
+	case 7:
+		return 5, nil
+	case 5, 6, 11, 15, 16:
+		return 8, nil
+	case 22:
+		return 10, nil
+	case 24:
+		if len(packet) < 4 {
+			return 0, nil
+		}
+		return 6 + int(packet[2])<<8 + int(packet[3]), nil
+	case 43:
+		if packet[2] == 0x0e {
+			return deviceIDResponseLength(packet), nil
+		}
+	}
+	return -1, nil
+}
+
+// deviceIDResponseLength returns the length of a read device identification
+// response, walking its objects, or 0 if more bytes are needed.
+func deviceIDResponseLength(packet []byte) int {
+	// Address, function, MEI type, read device ID code, conformity level,
+	// more follows, next object ID and number of objects.
+	if len(packet) < 8 {
+		return 0
+	}
+	length := 8
+	for i := 0; i < int(packet[7]); i++ {
+		if len(packet) < length+2 {
+			return 0
+		}
+		length += 2 + int(packet[length+1])
+	}
+	return length + 2
+}
+
+func (f *rtuFraming) response(packet []byte) (mbserver.Framer, error) {
+	return mbserver.NewRTUFrame(append([]byte(nil), packet...))
+}
+
+func (f *rtuFraming) matches(request, response mbserver.Framer) bool {
+	return response.GetAddress() == request.GetAddress() &&
+		response.GetFunction()&0x7f == request.GetFunction()
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/elcdrue/mbserver"
+)
+
+// tcpFraming frames requests with an MBAP header and matches the responses by
+// transaction identifier.
+type tcpFraming struct {
+	transactionID uint16
+}
+
+func (f *tcpFraming) request(unitID byte, function uint8) mbserver.Framer {
+	return &mbserver.TCPFrame{Device: unitID, Function: function}
+}
+
+// prepare gives each attempt a new transaction identifier, so a late response
+// to an earlier attempt is never taken for the response to this one.
+func (f *tcpFraming) prepare(request mbserver.Framer) {
+	f.transactionID++
+	request.(*mbserver.TCPFrame).TransactionIdentifier = f.transactionID
+}
+
+func (f *tcpFraming) responseLength(packet []byte) (int, error) {
+	if len(packet) < 7 {
+		return 0, nil
+	}
+	length := binary.BigEndian.Uint16(packet[4:6])
+	if length < 2 || length > 254 {
+		return 0, fmt.Errorf("TCP Frame error: bad MBAP length %d", length)
+	}
+	return 6 + int(length), nil
+}
+
+func (f *tcpFraming) response(packet []byte) (mbserver.Framer, error) {
+	frame, err := mbserver.NewTCPFrame(append([]byte(nil), packet...))
+	if err != nil {
+		return nil, err
+	}
+	if frame.Function&0x80 != 0 && len(frame.Data) != 1 {
+		return nil, fmt.Errorf("TCP Frame error: exception response without code")
+	}
+	return frame, nil
+}
+
+func (f *tcpFraming) matches(request, response mbserver.Framer) bool {
+	req := request.(*mbserver.TCPFrame)
+	resp := response.(*mbserver.TCPFrame)
+	return resp.TransactionIdentifier == req.TransactionIdentifier &&
+		resp.ProtocolIdentifier == 0 &&
+		resp.Device == req.Device &&
+		resp.Function&0x7f == req.Function
+}
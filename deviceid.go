@@ -0,0 +1,129 @@
+package mbserver
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Device identification object IDs (function 43, MEI type 14).
+const (
+	// Basic category, mandatory.
+	DeviceIDVendorName         byte = 0x00
+	DeviceIDProductCode        byte = 0x01
+	DeviceIDMajorMinorRevision byte = 0x02
+	// Regular category, optional.
+	DeviceIDVendorURL           byte = 0x03
+	DeviceIDProductName         byte = 0x04
+	DeviceIDModelName           byte = 0x05
+	DeviceIDUserApplicationName byte = 0x06
+	// Objects 0x80 to 0xFF form the extended category.
+)
+
+// Read device ID codes.
+const (
+	readDeviceIDBasic      = 0x01
+	readDeviceIDRegular    = 0x02
+	readDeviceIDExtended   = 0x03
+	readDeviceIDIndividual = 0x04
+)
+
+// maxDeviceIDObjectsSize is the space left in a PDU for the object list of a
+// Read Device Identification response.
+const maxDeviceIDObjectsSize = 253 - 7
+
+// SetDeviceIdentification sets a device identification object of the slave.
+// Objects 0x00 to 0x02 are basic, 0x03 to 0x7F regular and 0x80 to 0xFF
+// extended.
+func (m *SlaveMemory) SetDeviceIdentification(objectID byte, value string) error {
+	if len(value) > maxDeviceIDObjectsSize-2 {
+		return fmt.Errorf("device identification object 0x%02x: %d bytes exceeds %d", objectID, len(value), maxDeviceIDObjectsSize-2)
+	}
+
+	m.deviceIDMutex.Lock()
+	defer m.deviceIDMutex.Unlock()
+
+	if m.deviceID == nil {
+		m.deviceID = make(map[byte]string)
+	}
+	m.deviceID[objectID] = value
+	return nil
+}
+
+// DeviceIdentification returns a device identification object of the slave.
+func (m *SlaveMemory) DeviceIdentification(objectID byte) (string, bool) {
+	m.deviceIDMutex.Lock()
+	defer m.deviceIDMutex.Unlock()
+
+	value, ok := m.deviceID[objectID]
+	return value, ok
+}
+
+// SetDeviceIdentification sets a device identification object of a slave of
+// the server.
+func (s *Server) SetDeviceIdentification(slaveID byte, objectID byte, value string) error {
+	slave, err := s.Slave(slaveID)
+	if err != nil {
+		return err
+	}
+	return slave.SetDeviceIdentification(objectID, value)
+}
+
+// DeviceIdentification returns a device identification object of a slave of
+// the server.
+func (s *Server) DeviceIdentification(slaveID byte, objectID byte) (string, bool) {
+	slave, err := s.Slave(slaveID)
+	if err != nil {
+		return "", false
+	}
+	return slave.DeviceIdentification(objectID)
+}
+
+// deviceIDObjects returns the sorted object IDs of a slave and their values.
+// The mandatory basic objects are always present.
+func (m *SlaveMemory) deviceIDObjects() ([]byte, map[byte]string) {
+	m.deviceIDMutex.Lock()
+	defer m.deviceIDMutex.Unlock()
+
+	objects := map[byte]string{
+		DeviceIDVendorName:         "",
+		DeviceIDProductCode:        "",
+		DeviceIDMajorMinorRevision: "",
+	}
+	for id, value := range m.deviceID {
+		objects[id] = value
+	}
+	ids := make([]byte, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, objects
+}
+
+// deviceIDConformityLevel returns the conformity level reported by a slave,
+// based on the highest category of object it holds. Individual access is
+// always supported.
+func deviceIDConformityLevel(ids []byte) byte {
+	level := byte(readDeviceIDBasic)
+	for _, id := range ids {
+		if id >= 0x80 {
+			level = readDeviceIDExtended
+		} else if id > DeviceIDMajorMinorRevision && level < readDeviceIDRegular {
+			level = readDeviceIDRegular
+		}
+	}
+	return 0x80 | level
+}
+
+// deviceIDCategoryLimit returns the highest object ID streamed for a read
+// device ID code.
+func deviceIDCategoryLimit(code byte) byte {
+	switch code {
+	case readDeviceIDBasic:
+		return DeviceIDMajorMinorRevision
+	case readDeviceIDRegular:
+		return 0x7f
+	default:
+		return 0xff
+	}
+}
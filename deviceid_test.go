@@ -0,0 +1,41 @@
+package mbserver
+
+import "testing"
+
+func TestSetDeviceIdentification(t *testing.T) {
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	slave, _ := s.Slave(1)
+	if err := slave.SetDeviceIdentification(0x80, string(make([]byte, 245))); err == nil {
+		t.Errorf("expected error for object larger than a PDU")
+	}
+	if err := slave.SetDeviceIdentification(DeviceIDModelName, "M"); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	value, ok := slave.DeviceIdentification(DeviceIDModelName)
+	if !ok || value != "M" {
+		t.Errorf("expected M, got %v", value)
+	}
+
+	if err := s.SetDeviceIdentification(2, DeviceIDVendorName, "ACME"); err == nil {
+		t.Errorf("expected error for slave out of range")
+	}
+	if err := s.SetDeviceIdentification(1, DeviceIDVendorName, "ACME"); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	value, ok = slave.DeviceIdentification(DeviceIDVendorName)
+	if !ok || value != "ACME" {
+		t.Errorf("expected ACME, got %v", value)
+	}
+	if value, _ = s.DeviceIdentification(1, DeviceIDModelName); value != "M" {
+		t.Errorf("expected M, got %v", value)
+	}
+
+	ids, _ := slave.deviceIDObjects()
+	expect := byte(0x82)
+	got := deviceIDConformityLevel(ids)
+	if expect != got {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
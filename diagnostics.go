@@ -0,0 +1,188 @@
+package mbserver
+
+import "sync"
+
+// Serial line diagnostic counters, in the order of the function 8
+// sub-functions 0x0B to 0x12 returning them.
+const (
+	busMessageCount = iota
+	busCommunicationErrorCount
+	busExceptionErrorCount
+	serverMessageCount
+	serverNoResponseCount
+	serverNAKCount
+	serverBusyCount
+	busCharacterOverrunCount
+	diagnosticCounters
+)
+
+// Function 8 sub-functions.
+const (
+	diagReturnQueryData                = 0x00
+	diagRestartCommunications          = 0x01
+	diagReturnDiagnosticRegister       = 0x02
+	diagChangeASCIIInputDelimiter      = 0x03
+	diagForceListenOnlyMode            = 0x04
+	diagClearCounters                  = 0x0a
+	diagReturnBusMessageCount          = 0x0b
+	diagReturnBusCharacterOverrunCount = 0x12
+	diagClearOverrunCounter            = 0x14
+)
+
+// Communication event log bytes of function 12.
+const (
+	eventRestart         = 0x00
+	eventEnterListenOnly = 0x04
+
+	eventReceive                   = 0x80
+	eventReceiveCommunicationError = 0x02
+	eventReceiveCharacterOverrun   = 0x10
+	eventReceiveListenOnly         = 0x20
+	eventReceiveBroadcast          = 0x40
+	eventSend                      = 0x40
+	eventSendReadException         = 0x01
+	eventSendSlaveAbortException   = 0x02
+	eventSendSlaveBusyException    = 0x04
+	eventSendSlaveProgramNAK       = 0x08
+	maxEvents                      = 64
+)
+
+// diagnostics holds the diagnostic state of a serial port.
+type diagnostics struct {
+	mutex          sync.Mutex
+	counters       [diagnosticCounters]uint16
+	register       uint16
+	listenOnly     bool
+	asciiDelimiter byte
+	eventCounter   uint16
+	events         [maxEvents]byte // ring buffer of the communication event log
+	eventsNext     int
+	eventsLen      int
+}
+
+func newDiagnostics() *diagnostics {
+	return &diagnostics{asciiDelimiter: '\n'}
+}
+
+func (d *diagnostics) increment(counter int) {
+	d.mutex.Lock()
+	d.counters[counter]++
+	d.mutex.Unlock()
+}
+
+func (d *diagnostics) counter(counter int) uint16 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.counters[counter]
+}
+
+func (d *diagnostics) clear() {
+	d.mutex.Lock()
+	d.counters = [diagnosticCounters]uint16{}
+	d.register = 0
+	d.eventCounter = 0
+	d.mutex.Unlock()
+}
+
+// addEvent stores an event in the communication event log, overwriting the
+// oldest event when the log is full.
+func (d *diagnostics) addEvent(event byte) {
+	d.mutex.Lock()
+	d.events[d.eventsNext] = event
+	d.eventsNext = (d.eventsNext + 1) % maxEvents
+	if d.eventsLen < maxEvents {
+		d.eventsLen++
+	}
+	d.mutex.Unlock()
+}
+
+// eventLog returns the communication event log, most recent event first.
+func (d *diagnostics) eventLog() []byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	events := make([]byte, d.eventsLen)
+	for i := range events {
+		events[i] = d.events[(d.eventsNext-1-i+maxEvents)%maxEvents]
+	}
+	return events
+}
+
+func (d *diagnostics) clearEventLog() {
+	d.mutex.Lock()
+	d.eventsNext = 0
+	d.eventsLen = 0
+	d.mutex.Unlock()
+}
+
+func (d *diagnostics) incrementEventCounter() {
+	d.mutex.Lock()
+	d.eventCounter++
+	d.mutex.Unlock()
+}
+
+// receiveEvent stores the event for a message received by the server.
+func (d *diagnostics) receiveEvent(frame Framer, listenOnly bool) {
+	event := byte(eventReceive)
+	if frame.GetAddress() == 0 {
+		event |= eventReceiveBroadcast
+	}
+	if listenOnly {
+		event |= eventReceiveListenOnly
+	}
+	d.addEvent(event)
+}
+
+// sendEvent stores the event for a response sent by the server.
+func (d *diagnostics) sendEvent(exception Exception) {
+	event := byte(eventSend)
+	switch exception {
+	case Success:
+	case IllegalFunction, IllegalDataAddress, IllegalDataValue:
+		event |= eventSendReadException
+	case SlaveDeviceFailure:
+		event |= eventSendSlaveAbortException
+	case AcknowledgeSlave, SlaveDeviceBusy:
+		event |= eventSendSlaveBusyException
+	case NegativeAcknowledge:
+		event |= eventSendSlaveProgramNAK
+	}
+	d.addEvent(event)
+}
+
+func (d *diagnostics) isListenOnly() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.listenOnly
+}
+
+func (d *diagnostics) setListenOnly(listenOnly bool) {
+	d.mutex.Lock()
+	d.listenOnly = listenOnly
+	d.mutex.Unlock()
+}
+
+func (d *diagnostics) delimiter() byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.asciiDelimiter
+}
+
+// countException updates the counters for an exception response.
+func (d *diagnostics) countException(exception Exception) {
+	d.increment(busExceptionErrorCount)
+	switch exception {
+	case NegativeAcknowledge:
+		d.increment(serverNAKCount)
+	case SlaveDeviceBusy:
+		d.increment(serverBusyCount)
+	}
+}
+
+// isRestartCommunications returns true for a function 8 Restart
+// Communications Option request, the only request processed in listen only
+// mode.
+func isRestartCommunications(frame Framer) bool {
+	data := frame.GetData()
+	return frame.GetFunction() == 8 && len(data) >= 2 && data[0] == 0 && data[1] == diagRestartCommunications
+}
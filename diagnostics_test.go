@@ -0,0 +1,23 @@
+package mbserver
+
+import "testing"
+
+func TestEventLogWraps(t *testing.T) {
+	diag := newDiagnostics()
+	for i := 0; i < maxEvents+2; i++ {
+		diag.addEvent(byte(i))
+	}
+
+	events := diag.eventLog()
+	if len(events) != maxEvents {
+		t.Fatalf("expected %v events, got %v", maxEvents, len(events))
+	}
+	if events[0] != maxEvents+1 || events[maxEvents-1] != 2 {
+		t.Errorf("expected events %v..2, got %v..%v", maxEvents+1, events[0], events[maxEvents-1])
+	}
+
+	diag.clearEventLog()
+	if events := diag.eventLog(); len(events) != 0 {
+		t.Errorf("expected empty event log, got %v", events)
+	}
+}
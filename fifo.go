@@ -0,0 +1,54 @@
+package mbserver
+
+import "fmt"
+
+// MaxFIFOCount is the maximum number of registers a FIFO queue may hold.
+const MaxFIFOCount = 31
+
+// PushFIFO appends values to the FIFO queue at the given pointer address,
+// creating the queue if needed. The queue is left unchanged and an error
+// returned if the values do not fit.
+func (m *SlaveMemory) PushFIFO(address uint16, values ...uint16) error {
+	m.fifoMutex.Lock()
+	defer m.fifoMutex.Unlock()
+
+	queue := m.fifoQueues[address]
+	if len(queue)+len(values) > MaxFIFOCount {
+		return fmt.Errorf("FIFO queue 0x%x full: %d + %d values exceeds %d", address, len(queue), len(values), MaxFIFOCount)
+	}
+	m.fifoQueues[address] = append(queue, values...)
+	return nil
+}
+
+// PopFIFO removes and returns the oldest value of the FIFO queue at the given
+// pointer address. The second return value is false if the queue is empty.
+func (m *SlaveMemory) PopFIFO(address uint16) (uint16, bool) {
+	m.fifoMutex.Lock()
+	defer m.fifoMutex.Unlock()
+
+	queue := m.fifoQueues[address]
+	if len(queue) == 0 {
+		return 0, false
+	}
+	value := queue[0]
+	m.fifoQueues[address] = queue[1:]
+	return value, true
+}
+
+// ClearFIFO removes the FIFO queue at the given pointer address.
+func (m *SlaveMemory) ClearFIFO(address uint16) {
+	m.fifoMutex.Lock()
+	defer m.fifoMutex.Unlock()
+
+	delete(m.fifoQueues, address)
+}
+
+// FIFO returns a copy of the FIFO queue at the given pointer address and
+// whether the queue exists.
+func (m *SlaveMemory) FIFO(address uint16) ([]uint16, bool) {
+	m.fifoMutex.Lock()
+	defer m.fifoMutex.Unlock()
+
+	queue, ok := m.fifoQueues[address]
+	return append([]uint16(nil), queue...), ok
+}
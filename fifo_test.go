@@ -0,0 +1,47 @@
+package mbserver
+
+import "testing"
+
+func TestFIFO(t *testing.T) {
+	var LowerID, UpperID byte = 1, 2
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	slave, err := s.Slave(2)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if _, err := s.Slave(3); err == nil {
+		t.Errorf("expected error for slave id out of range")
+	}
+
+	err = slave.PushFIFO(10, make([]uint16, MaxFIFOCount-1)...)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	err = slave.PushFIFO(10, 1, 2)
+	if err == nil {
+		t.Errorf("expected error when the queue overflows")
+	}
+	err = slave.PushFIFO(10, 7)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	values, ok := slave.FIFO(10)
+	if !ok || len(values) != MaxFIFOCount {
+		t.Errorf("expected %v values, got %v", MaxFIFOCount, len(values))
+	}
+
+	value, ok := slave.PopFIFO(10)
+	if !ok || value != 0 {
+		t.Errorf("expected 0, got %v", value)
+	}
+
+	slave.ClearFIFO(10)
+	if _, ok := slave.FIFO(10); ok {
+		t.Errorf("expected queue to be removed")
+	}
+	if _, ok := slave.PopFIFO(10); ok {
+		t.Errorf("expected empty queue")
+	}
+}
@@ -0,0 +1,82 @@
+package mbserver
+
+import "fmt"
+
+// MaxFileRecord is the highest record number addressable within a file.
+const MaxFileRecord = 9999
+
+// fileReferenceType is the only reference type defined for file records.
+const fileReferenceType = 6
+
+// SetFileRecords writes values to consecutive records of a file starting at
+// record, creating or extending the file as needed. Files are numbered 1 to
+// 65535 and records 0 to MaxFileRecord.
+func (m *SlaveMemory) SetFileRecords(file uint16, record uint16, values ...uint16) error {
+	if file == 0 {
+		return fmt.Errorf("file number 0 is not allowed")
+	}
+	end := int(record) + len(values)
+	if end > MaxFileRecord+1 {
+		return fmt.Errorf("file %d: records %d..%d exceed %d", file, record, end-1, MaxFileRecord)
+	}
+
+	m.fileMutex.Lock()
+	defer m.fileMutex.Unlock()
+
+	if m.files == nil {
+		m.files = make(map[uint16][]uint16)
+	}
+	records := m.files[file]
+	if len(records) < end {
+		records = append(records, make([]uint16, end-len(records))...)
+	}
+	copy(records[record:], values)
+	m.files[file] = records
+	return nil
+}
+
+// FileRecords returns a copy of length records of a file starting at record.
+func (m *SlaveMemory) FileRecords(file uint16, record uint16, length uint16) ([]uint16, error) {
+	m.fileMutex.Lock()
+	defer m.fileMutex.Unlock()
+
+	records, ok := m.files[file]
+	if !ok {
+		return nil, fmt.Errorf("file %d does not exist", file)
+	}
+	end := int(record) + int(length)
+	if end > len(records) {
+		return nil, fmt.Errorf("file %d: records %d..%d exceed file length %d", file, record, end-1, len(records))
+	}
+	return append([]uint16(nil), records[record:end]...), nil
+}
+
+// DeleteFile removes a file and all its records.
+func (m *SlaveMemory) DeleteFile(file uint16) {
+	m.fileMutex.Lock()
+	defer m.fileMutex.Unlock()
+
+	delete(m.files, file)
+}
+
+// fileSubRequest is a single sub-request of a function 20 or 21 request.
+type fileSubRequest struct {
+	file   uint16
+	record uint16
+	length uint16
+	values []byte
+}
+
+// validFileSubRequest checks the reference type and that the addressed
+// records exist in the file.
+func (m *SlaveMemory) validFileSubRequest(referenceType byte, sub fileSubRequest) bool {
+	if referenceType != fileReferenceType || sub.file == 0 || sub.record > MaxFileRecord {
+		return false
+	}
+
+	m.fileMutex.Lock()
+	defer m.fileMutex.Unlock()
+
+	records, ok := m.files[sub.file]
+	return ok && int(sub.record)+int(sub.length) <= len(records)
+}
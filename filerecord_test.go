@@ -0,0 +1,33 @@
+package mbserver
+
+import "testing"
+
+func TestFileRecords(t *testing.T) {
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	slave, _ := s.Slave(1)
+
+	if err := slave.SetFileRecords(0, 0, 1); err == nil {
+		t.Errorf("expected error for file number 0")
+	}
+	if err := slave.SetFileRecords(1, MaxFileRecord, 1, 2); err == nil {
+		t.Errorf("expected error for records beyond %d", MaxFileRecord)
+	}
+	if err := slave.SetFileRecords(1, MaxFileRecord, 1); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	values, err := slave.FileRecords(1, MaxFileRecord-1, 2)
+	expect := []uint16{0, 1}
+	if err != nil || !isEqual(expect, values) {
+		t.Errorf("expected %v, got %v (%v)", expect, values, err)
+	}
+	if _, err := slave.FileRecords(1, MaxFileRecord, 2); err == nil {
+		t.Errorf("expected error reading beyond the end of the file")
+	}
+
+	slave.DeleteFile(1)
+	if _, err := slave.FileRecords(1, 0, 1); err == nil {
+		t.Errorf("expected error reading a deleted file")
+	}
+}
@@ -13,6 +13,18 @@ type Framer interface {
 	GetAddress() byte
 }
 
+// unitFrame overrides the unit ID of a frame, so a handler can be executed on
+// a slave other than the one addressed by the request.
+type unitFrame struct {
+	Framer
+	address byte
+}
+
+// GetAddress returns the overridden Modbus Slave Unit ID.
+func (frame *unitFrame) GetAddress() byte {
+	return frame.address
+}
+
 // GetException retunrns the Modbus exception or Success (indicating not exception).
 func GetException(frame Framer) (exception Exception) {
 	function := frame.GetFunction()
@@ -22,19 +34,51 @@ func GetException(frame Framer) (exception Exception) {
 	return exception
 }
 
-func registerAddressAndNumber(frame Framer) (register uint16, numRegs uint16, endRegister uint32) {
+// minRequestDataLength returns the length of the shortest request data of a
+// function served by default. Requests of functions 7, 11, 12 and 17 have no
+// data, the data of other requests is checked by the server before they are
+// dispatched.
+func minRequestDataLength(function uint8) int {
+	switch function {
+	case 1, 2, 3, 4, 5, 6:
+		return 4
+	case 8, 24:
+		return 2
+	case 15, 16:
+		return 5
+	case 20, 21, 43:
+		return 1
+	case 22:
+		return 6
+	case 23:
+		return 9
+	}
+	return 0
+}
+
+// registerAddressAndNumber returns the starting register and number of
+// registers of a request, IllegalDataValue if its data is too short.
+func registerAddressAndNumber(frame Framer) (register uint16, numRegs uint16, endRegister uint32, exception *Exception) {
 	data := frame.GetData()
+	if len(data) < 4 {
+		return 0, 0, 0, &IllegalDataValue
+	}
 	register = binary.BigEndian.Uint16(data[0:2])
 	numRegs = binary.BigEndian.Uint16(data[2:4])
 	endRegister = uint32(register) + uint32(numRegs)
-	return register, numRegs, endRegister
+	return register, numRegs, endRegister, &Success
 }
 
-func registerAddressAndValue(frame Framer) (uint16, uint16) {
+// registerAddressAndValue returns the register and value of a request,
+// IllegalDataValue if its data is too short.
+func registerAddressAndValue(frame Framer) (uint16, uint16, *Exception) {
 	data := frame.GetData()
+	if len(data) < 4 {
+		return 0, 0, &IllegalDataValue
+	}
 	register := binary.BigEndian.Uint16(data[0:2])
 	value := binary.BigEndian.Uint16(data[2:4])
-	return register, value
+	return register, value, &Success
 }
 
 // SetDataWithRegisterAndNumber sets the RTUFrame Data byte field to hold a register and number of registers
@@ -0,0 +1,110 @@
+package mbserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ASCIIFrame is the Modbus ASCII frame.
+type ASCIIFrame struct {
+	Address  uint8
+	Function uint8
+	Data     []byte
+	LRC      uint8
+}
+
+// NewASCIIFrame converts a packet to a Modbus ASCII frame. The packet starts
+// with a colon and ends with a carriage return followed by the input
+// delimiter (line feed by default).
+func NewASCIIFrame(packet []byte) (*ASCIIFrame, error) {
+	// Check the that the packet length.
+	pLen := len(packet)
+	if pLen < 9 {
+		return nil, fmt.Errorf("ASCII Frame error: packet less than 9 bytes: %q", packet)
+	}
+
+	// Check the start and end of the frame.
+	if packet[0] != ':' || packet[pLen-2] != '\r' {
+		return nil, fmt.Errorf("ASCII Frame error: bad start or end of frame: %q", packet)
+	}
+
+	bytes := make([]byte, hex.DecodedLen(pLen-3))
+	_, err := hex.Decode(bytes, packet[1:pLen-2])
+	if err != nil {
+		return nil, fmt.Errorf("ASCII Frame error: %v", err)
+	}
+
+	// Check the LRC.
+	bLen := len(bytes)
+	lrcExpect := bytes[bLen-1]
+	lrcCalc := lrcModbus(bytes[0 : bLen-1])
+	if lrcCalc != lrcExpect {
+		return nil, fmt.Errorf("ASCII Frame error: LRC (expected 0x%x, got 0x%x)", lrcExpect, lrcCalc)
+	}
+
+	frame := &ASCIIFrame{
+		Address:  bytes[0],
+		Function: bytes[1],
+		Data:     bytes[2 : bLen-1],
+		LRC:      lrcExpect,
+	}
+
+	return frame, nil
+}
+
+// Copy the ASCIIFrame.
+func (frame *ASCIIFrame) Copy() Framer {
+	copy := *frame
+	return &copy
+}
+
+// Bytes returns the Modbus byte stream based on the ASCIIFrame fields
+func (frame *ASCIIFrame) Bytes() []byte {
+	bytes := make([]byte, 2)
+
+	bytes[0] = frame.Address
+	bytes[1] = frame.Function
+	bytes = append(bytes, frame.Data...)
+
+	// Add the LRC.
+	bytes = append(bytes, lrcModbus(bytes))
+
+	return []byte(":" + strings.ToUpper(hex.EncodeToString(bytes)) + "\r\n")
+}
+
+// GetFunction returns the Modbus function code.
+func (frame *ASCIIFrame) GetFunction() uint8 {
+	return frame.Function
+}
+
+// GetData returns the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) GetData() []byte {
+	return frame.Data
+}
+
+// GetAddress returns the Modbus Slave Unit ID
+func (frame *ASCIIFrame) GetAddress() byte {
+	return frame.Address
+}
+
+// SetData sets the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) SetData(data []byte) {
+	frame.Data = data
+}
+
+// SetException sets the Modbus exception code in the frame.
+func (frame *ASCIIFrame) SetException(exception *Exception) {
+	frame.Function = frame.Function | 0x80
+	frame.Data = []byte{byte(*exception)}
+}
+
+// lrcModbus returns the longitudinal redundancy check of data: the two's
+// complement of the sum of all bytes.
+func lrcModbus(data []byte) uint8 {
+	var sum uint8
+	for _, v := range data {
+		sum += v
+	}
+	return -sum
+}
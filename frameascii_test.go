@@ -0,0 +1,64 @@
+package mbserver
+
+import "testing"
+
+func TestNewASCIIFrame(t *testing.T) {
+	frame, err := NewASCIIFrame([]byte(":010402FFFFFB\r\n"))
+	if !isEqual(nil, err) {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+
+	got := frame.Address
+	expect := 1
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	got = frame.Function
+	expect = 4
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	expectData := []byte{0x02, 0xff, 0xff}
+	gotData := frame.Data
+	if !isEqual(expectData, gotData) {
+		t.Errorf("expected %v, got %v", expectData, gotData)
+	}
+}
+
+func TestNewASCIIFrameShortPacket(t *testing.T) {
+	_, err := NewASCIIFrame([]byte(":0104\r\n"))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestNewASCIIFrameBadLRC(t *testing.T) {
+	// Bad LRC: 0xFC (should be 0xFB)
+	_, err := NewASCIIFrame([]byte(":010402FFFFFC\r\n"))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestNewASCIIFrameBadHex(t *testing.T) {
+	_, err := NewASCIIFrame([]byte(":010402FFFGFB\r\n"))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestASCIIFrameBytes(t *testing.T) {
+	frame := &ASCIIFrame{
+		Address:  uint8(1),
+		Function: uint8(4),
+		Data:     []byte{0x02, 0xff, 0xff},
+	}
+
+	got := string(frame.Bytes())
+	expect := ":010402FFFFFB\r\n"
+	if !isEqual(expect, got) {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
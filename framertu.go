@@ -15,9 +15,9 @@ type RTUFrame struct {
 
 // NewRTUFrame converts a packet to a Modbus TCP frame.
 func NewRTUFrame(packet []byte) (*RTUFrame, error) {
-	// Check the that the packet length.
-	if len(packet) < 5 {
-		return nil, fmt.Errorf("RTU Frame error: packet less than 5 bytes: %v", packet)
+	// Check the that the packet length, a request may have no data.
+	if len(packet) < 4 {
+		return nil, fmt.Errorf("RTU Frame error: packet less than 4 bytes: %v", packet)
 	}
 
 	// Check the CRC.
@@ -37,6 +37,58 @@ func NewRTUFrame(packet []byte) (*RTUFrame, error) {
 	return frame, nil
 }
 
+// rtuRequestLength returns the expected length of the RTU request frame at the
+// start of the packet, derived from its function code. It returns 0 if more
+// bytes are needed to tell and -1 if the function code does not define the
+// length.
+func rtuRequestLength(packet []byte) int {
+	if len(packet) < 2 {
+		return 0
+	}
+	// byteCount returns the length of a frame holding a byte count at index i
+	// followed by extra bytes and the data.
+	byteCount := func(i int, extra int) int {
+		if len(packet) <= i {
+			return 0
+		}
+		return i + 1 + int(packet[i]) + extra
+	}
+
+	switch packet[1] {
+	case 7, 11, 12, 17:
+		return 4
+	case 24:
+		return 6
+	case 1, 2, 3, 4, 5, 6:
+		return 8
+	case 8:
+		// Return Query Data (sub-function 0) echoes data of any length.
+		if len(packet) < 4 {
+			return 0
+		}
+		if packet[2] == 0 && packet[3] == 0 {
+			return -1
+		}
+		return 8
+	case 22:
+		return 10
+	case 15, 16:
+		return byteCount(6, 2)
+	case 20, 21:
+		return byteCount(2, 2)
+	case 23:
+		return byteCount(10, 2)
+	case 43:
+		if len(packet) < 3 {
+			return 0
+		}
+		if packet[2] == 0x0e {
+			return 7
+		}
+	}
+	return -1
+}
+
 // Copy the RTUFrame.
 func (frame *RTUFrame) Copy() Framer {
 	copy := *frame
@@ -21,6 +21,21 @@ func TestNewRTUFrame(t *testing.T) {
 	}
 }
 
+func TestNewRTUFrameNoData(t *testing.T) {
+	// Function 11 requests have no data.
+	packet := (&RTUFrame{Address: 1, Function: 11}).Bytes()
+	frame, err := NewRTUFrame(packet)
+	if !isEqual(nil, err) {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+
+	got := frame.GetData()
+	expect := []byte{}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
 func TestNewRTUFrameShortPacket(t *testing.T) {
 	_, err := NewRTUFrame([]byte{0x01, 0x04, 0xFF, 0xFF})
 	if err == nil {
@@ -49,3 +64,28 @@ func TestRTUFrameBytes(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestRTURequestLength(t *testing.T) {
+	tests := []struct {
+		packet []byte
+		expect int
+	}{
+		{[]byte{0x01}, 0},
+		{[]byte{0x01, 0x03}, 8},
+		{[]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x02}, 0},
+		{[]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x02, 0x04}, 13},
+		{[]byte{0x01, 0x17, 0, 0, 0, 1, 0, 0, 0, 1, 0x02}, 15},
+		{[]byte{0x01, 0x08, 0x00}, 0},
+		{[]byte{0x01, 0x08, 0x00, 0x00}, -1},
+		{[]byte{0x01, 0x08, 0x00, 0x0a}, 8},
+		{[]byte{0x01, 0x2b, 0x0e}, 7},
+		{[]byte{0x01, 0x2b, 0x0d}, -1},
+		{[]byte{0x01, 0x64}, -1},
+	}
+	for _, test := range tests {
+		got := rtuRequestLength(test.packet)
+		if test.expect != got {
+			t.Errorf("%v: expected %v, got %v", test.packet, test.expect, got)
+		}
+	}
+}
@@ -17,9 +17,9 @@ type TCPFrame struct {
 
 // NewTCPFrame converts a packet to a Modbus TCP frame.
 func NewTCPFrame(packet []byte) (*TCPFrame, error) {
-	// Check if the packet is too short.
-	if len(packet) < 9 {
-		return nil, fmt.Errorf("TCP Frame error: packet less than 9 bytes")
+	// Check if the packet is too short, a request may have no data.
+	if len(packet) < 8 {
+		return nil, fmt.Errorf("TCP Frame error: packet less than 8 bytes")
 	}
 
 	frame := &TCPFrame{
@@ -39,6 +39,22 @@ func NewTCPFrame(packet []byte) (*TCPFrame, error) {
 	return frame, nil
 }
 
+// tcpFrameLength returns the length of the Modbus TCP frame at the start of
+// the packet, given by its MBAP header. It returns 0 if the header is
+// incomplete.
+func tcpFrameLength(packet []byte) (int, error) {
+	if len(packet) < 7 {
+		return 0, nil
+	}
+	// The length counts the unit identifier, function code and at most 252
+	// data bytes.
+	length := binary.BigEndian.Uint16(packet[4:6])
+	if length < 2 || length > 254 {
+		return 0, fmt.Errorf("TCP Frame error: bad MBAP length %d", length)
+	}
+	return 6 + int(length), nil
+}
+
 // Copy the TCPFrame.
 func (frame *TCPFrame) Copy() Framer {
 	copy := *frame
@@ -1,13 +1,17 @@
 package mbserver
 
 import (
+	"context"
 	"encoding/binary"
 	"log"
 )
 
 // ReadCoils function 1, reads coils from internal memory.
 func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
+	register, numRegs, endRegister, exception := registerAddressAndNumber(frame)
+	if exception != &Success {
+		return []byte{}, exception
+	}
 
 	if (int(register) + int(numRegs)) > 65536 {
 		return []byte{}, &IllegalDataAddress
@@ -31,7 +35,10 @@ func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
 
 // ReadDiscreteInputs function 2, reads discrete inputs from internal memory.
 func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
+	register, numRegs, endRegister, exception := registerAddressAndNumber(frame)
+	if exception != &Success {
+		return []byte{}, exception
+	}
 
 	if (int(register) + int(numRegs)) > 65536 {
 		return []byte{}, &IllegalDataAddress
@@ -55,7 +62,10 @@ func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
 
 // ReadHoldingRegisters function 3, reads holding registers from internal memory.
 func ReadHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
+	register, numRegs, endRegister, exception := registerAddressAndNumber(frame)
+	if exception != &Success {
+		return []byte{}, exception
+	}
 	if (int(register) + int(numRegs)) > 65536 {
 		return []byte{}, &IllegalDataAddress
 	}
@@ -66,7 +76,10 @@ func ReadHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
 
 // ReadInputRegisters function 4, reads input registers from internal memory.
 func ReadInputRegisters(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
+	register, numRegs, endRegister, exception := registerAddressAndNumber(frame)
+	if exception != &Success {
+		return []byte{}, exception
+	}
 	if (int(register) + int(numRegs)) > 65536 {
 		return []byte{}, &IllegalDataAddress
 	}
@@ -77,7 +90,10 @@ func ReadInputRegisters(s *Server, frame Framer) ([]byte, *Exception) {
 
 // WriteSingleCoil function 5, write a coil to internal memory.
 func WriteSingleCoil(s *Server, frame Framer) ([]byte, *Exception) {
-	register, value := registerAddressAndValue(frame)
+	register, value, exception := registerAddressAndValue(frame)
+	if exception != &Success {
+		return []byte{}, exception
+	}
 	// TODO Should we use 0 for off and 65,280 (FF00 in hexadecimal) for on?
 	if value != 0 {
 		value = 1
@@ -95,7 +111,10 @@ func WriteSingleCoil(s *Server, frame Framer) ([]byte, *Exception) {
 
 // WriteHoldingRegister function 6, write a holding register to internal memory.
 func WriteHoldingRegister(s *Server, frame Framer) ([]byte, *Exception) {
-	register, value := registerAddressAndValue(frame)
+	register, value, exception := registerAddressAndValue(frame)
+	if exception != &Success {
+		return []byte{}, exception
+	}
 	slaveID := frame.GetAddress()
 	idx := s.upperSlaveId - slaveID
 	s.slaves[idx].HoldingRegisters[register] = value
@@ -107,9 +126,121 @@ func WriteHoldingRegister(s *Server, frame Framer) ([]byte, *Exception) {
 	return frame.GetData()[0:4], &Success
 }
 
+// ReadExceptionStatus function 7, reads the exception status byte of a slave.
+func ReadExceptionStatus(s *Server, frame Framer) ([]byte, *Exception) {
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+	return []byte{s.slaves[idx].exceptionStatusByte()}, &Success
+}
+
+// Diagnostics function 8, serial line diagnostics of the port the request was
+// received on.
+func Diagnostics(ctx context.Context, request *Request) ([]byte, *Exception) {
+	diag := request.diagnostics
+	if diag == nil {
+		return []byte{}, &IllegalFunction
+	}
+	data := request.Frame().GetData()
+	if len(data) < 2 {
+		return []byte{}, &IllegalDataValue
+	}
+	subFunction := binary.BigEndian.Uint16(data[0:2])
+	if subFunction == diagReturnQueryData {
+		return data, &Success
+	}
+	if len(data) != 4 {
+		return []byte{}, &IllegalDataValue
+	}
+	value := binary.BigEndian.Uint16(data[2:4])
+	if value != 0 && subFunction != diagRestartCommunications && subFunction != diagChangeASCIIInputDelimiter {
+		return []byte{}, &IllegalDataValue
+	}
+
+	response := make([]byte, 4)
+	copy(response, data[0:2])
+	switch {
+	case subFunction == diagRestartCommunications:
+		if value != 0 && value != 0xff00 {
+			return []byte{}, &IllegalDataValue
+		}
+		diag.clear()
+		if value == 0xff00 {
+			diag.clearEventLog()
+		}
+		diag.setListenOnly(false)
+		diag.addEvent(eventRestart)
+		binary.BigEndian.PutUint16(response[2:4], value)
+	case subFunction == diagReturnDiagnosticRegister:
+		diag.mutex.Lock()
+		binary.BigEndian.PutUint16(response[2:4], diag.register)
+		diag.mutex.Unlock()
+	case subFunction == diagChangeASCIIInputDelimiter:
+		if value&0xff != 0 {
+			return []byte{}, &IllegalDataValue
+		}
+		diag.mutex.Lock()
+		diag.asciiDelimiter = byte(value >> 8)
+		diag.mutex.Unlock()
+		binary.BigEndian.PutUint16(response[2:4], value)
+	case subFunction == diagForceListenOnlyMode:
+		// No response is returned.
+		diag.setListenOnly(true)
+		diag.addEvent(eventEnterListenOnly)
+	case subFunction == diagClearCounters:
+		diag.clear()
+	case subFunction >= diagReturnBusMessageCount && subFunction <= diagReturnBusCharacterOverrunCount:
+		binary.BigEndian.PutUint16(response[2:4], diag.counter(int(subFunction-diagReturnBusMessageCount)))
+	case subFunction == diagClearOverrunCounter:
+		diag.mutex.Lock()
+		diag.counters[busCharacterOverrunCount] = 0
+		diag.mutex.Unlock()
+	default:
+		return []byte{}, &IllegalFunction
+	}
+
+	return response, &Success
+}
+
+// GetCommEventCounter function 11, returns the status word and the event
+// counter of the port the request was received on.
+func GetCommEventCounter(ctx context.Context, request *Request) ([]byte, *Exception) {
+	diag := request.diagnostics
+	if diag == nil {
+		return []byte{}, &IllegalFunction
+	}
+
+	// Requests are handled synchronously, so the server is never busy.
+	data := make([]byte, 4)
+	diag.mutex.Lock()
+	binary.BigEndian.PutUint16(data[2:4], diag.eventCounter)
+	diag.mutex.Unlock()
+	return data, &Success
+}
+
+// GetCommEventLog function 12, returns the status word, event counter, message
+// count and event log of the port the request was received on.
+func GetCommEventLog(ctx context.Context, request *Request) ([]byte, *Exception) {
+	diag := request.diagnostics
+	if diag == nil {
+		return []byte{}, &IllegalFunction
+	}
+
+	events := diag.eventLog()
+	data := make([]byte, 7, 7+len(events))
+	data[0] = byte(6 + len(events))
+	diag.mutex.Lock()
+	binary.BigEndian.PutUint16(data[3:5], diag.eventCounter)
+	binary.BigEndian.PutUint16(data[5:7], diag.counters[busMessageCount])
+	diag.mutex.Unlock()
+	return append(data, events...), &Success
+}
+
 // WriteMultipleCoils function 15, writes holding registers to internal memory.
 func WriteMultipleCoils(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, _ := registerAddressAndNumber(frame)
+	register, numRegs, _, exception := registerAddressAndNumber(frame)
+	if exception != &Success || len(frame.GetData()) < 5 {
+		return []byte{}, &IllegalDataValue
+	}
 	valueBytes := frame.GetData()[5:]
 
 	if (int(register) + int(numRegs)) > 65536 {
@@ -144,9 +275,11 @@ func WriteMultipleCoils(s *Server, frame Framer) ([]byte, *Exception) {
 
 // WriteHoldingRegisters function 16, writes holding registers to internal memory.
 func WriteHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, _ := registerAddressAndNumber(frame)
+	register, numRegs, _, exception := registerAddressAndNumber(frame)
+	if exception != &Success || len(frame.GetData()) < 5 {
+		return []byte{}, &IllegalDataValue
+	}
 	valueBytes := frame.GetData()[5:]
-	var exception *Exception
 	var data []byte
 
 	if uint16(len(valueBytes)/2) != numRegs || (int(register)+int(numRegs)) > 65535 {
@@ -175,6 +308,249 @@ func WriteHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
 	return data, exception
 }
 
+// ReportServerID function 17, reads the server ID, run indicator status and
+// additional data of a slave.
+func ReportServerID(s *Server, frame Framer) ([]byte, *Exception) {
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+	return s.slaves[idx].reportServerID(slaveID), &Success
+}
+
+// ReadFileRecord function 20, reads groups of file records from internal memory.
+func ReadFileRecord(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 8 || int(data[0]) != len(data)-1 || data[0] > 0xf5 || data[0]%7 != 0 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+	response := []byte{0}
+	for i := 1; i < len(data); i += 7 {
+		sub := fileSubRequest{
+			file:   binary.BigEndian.Uint16(data[i+1 : i+3]),
+			record: binary.BigEndian.Uint16(data[i+3 : i+5]),
+			length: binary.BigEndian.Uint16(data[i+5 : i+7]),
+		}
+		if !s.slaves[idx].validFileSubRequest(data[i], sub) {
+			return []byte{}, &IllegalDataAddress
+		}
+		values, err := s.slaves[idx].FileRecords(sub.file, sub.record, sub.length)
+		if err != nil {
+			return []byte{}, &IllegalDataAddress
+		}
+		// The response must fit in a single PDU.
+		if len(response)-1+2+len(values)*2 > 0xf5 {
+			return []byte{}, &IllegalDataValue
+		}
+		response = append(response, byte(1+len(values)*2), fileReferenceType)
+		response = append(response, Uint16ToBytes(values)...)
+	}
+	response[0] = byte(len(response) - 1)
+
+	return response, &Success
+}
+
+// WriteFileRecord function 21, writes groups of file records to internal memory.
+// All sub-requests are validated before any record is written.
+func WriteFileRecord(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 10 || int(data[0]) != len(data)-1 || data[0] > 0xfb {
+		return []byte{}, &IllegalDataValue
+	}
+
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+	var subs []fileSubRequest
+	for i := 1; i < len(data); {
+		if len(data)-i < 7 {
+			return []byte{}, &IllegalDataValue
+		}
+		sub := fileSubRequest{
+			file:   binary.BigEndian.Uint16(data[i+1 : i+3]),
+			record: binary.BigEndian.Uint16(data[i+3 : i+5]),
+			length: binary.BigEndian.Uint16(data[i+5 : i+7]),
+		}
+		end := i + 7 + int(sub.length)*2
+		if end > len(data) {
+			return []byte{}, &IllegalDataValue
+		}
+		sub.values = data[i+7 : end]
+		if !s.slaves[idx].validFileSubRequest(data[i], sub) {
+			return []byte{}, &IllegalDataAddress
+		}
+		subs = append(subs, sub)
+		i = end
+	}
+
+	for _, sub := range subs {
+		err := s.slaves[idx].SetFileRecords(sub.file, sub.record, BytesToUint16(sub.values)...)
+		if err != nil {
+			return []byte{}, &SlaveDeviceFailure
+		}
+	}
+
+	return data, &Success
+}
+
+// MaskWriteRegister function 22, modifies a holding register in internal memory
+// using a combination of an AND mask and an OR mask.
+func MaskWriteRegister(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) != 6 {
+		return []byte{}, &IllegalDataValue
+	}
+	register := binary.BigEndian.Uint16(data[0:2])
+	andMask := binary.BigEndian.Uint16(data[2:4])
+	orMask := binary.BigEndian.Uint16(data[4:6])
+
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+	// Requests are handled synchronously, so the read-modify-write is atomic.
+	value := (s.slaves[idx].HoldingRegisters[register] & andMask) | (orMask &^ andMask)
+	s.slaves[idx].HoldingRegisters[register] = value
+	// copy value from holding register with offset
+	if register >= s.offsetInputRegisters {
+		s.slaves[idx].InputRegisters[register-s.offsetInputRegisters] = value
+	}
+
+	return data[0:6], &Success
+}
+
+// ReadWriteMultipleRegisters function 23, writes holding registers to internal
+// memory and then reads holding registers back in a single transaction.
+func ReadWriteMultipleRegisters(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 9 {
+		return []byte{}, &IllegalDataValue
+	}
+	readRegister := binary.BigEndian.Uint16(data[0:2])
+	readNumRegs := binary.BigEndian.Uint16(data[2:4])
+	writeRegister := binary.BigEndian.Uint16(data[4:6])
+	writeNumRegs := binary.BigEndian.Uint16(data[6:8])
+	byteCount := data[8]
+	valueBytes := data[9:]
+
+	if readNumRegs < 1 || readNumRegs > 125 || writeNumRegs < 1 || writeNumRegs > 121 {
+		return []byte{}, &IllegalDataValue
+	}
+	if int(byteCount) != int(writeNumRegs)*2 || len(valueBytes) != int(byteCount) {
+		return []byte{}, &IllegalDataValue
+	}
+	if (int(readRegister)+int(readNumRegs)) > 65536 || (int(writeRegister)+int(writeNumRegs)) > 65536 {
+		return []byte{}, &IllegalDataAddress
+	}
+
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+
+	// The write operation is performed before the read.
+	values := BytesToUint16(valueBytes)
+	copy(s.slaves[idx].HoldingRegisters[writeRegister:], values)
+	// copy value from holding register with offset
+	if writeRegister >= s.offsetInputRegisters {
+		copy(s.slaves[idx].InputRegisters[writeRegister-s.offsetInputRegisters:], values)
+	}
+
+	endRegister := uint32(readRegister) + uint32(readNumRegs)
+	return append([]byte{byte(readNumRegs * 2)}, Uint16ToBytes(s.slaves[idx].HoldingRegisters[readRegister:endRegister])...), &Success
+}
+
+// ReadFIFOQueue function 24, reads the contents of a FIFO queue of registers
+// from internal memory.
+func ReadFIFOQueue(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) != 2 {
+		return []byte{}, &IllegalDataValue
+	}
+	address := binary.BigEndian.Uint16(data[0:2])
+
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+	values, ok := s.slaves[idx].FIFO(address)
+	if !ok {
+		return []byte{}, &IllegalDataAddress
+	}
+
+	// PushFIFO keeps queues within MaxFIFOCount values.
+	response := make([]byte, 4, 4+len(values)*2)
+	binary.BigEndian.PutUint16(response[0:2], uint16(2+len(values)*2))
+	binary.BigEndian.PutUint16(response[2:4], uint16(len(values)))
+	return append(response, Uint16ToBytes(values)...), &Success
+}
+
+// EncapsulatedInterfaceTransport function 43, dispatches the request to the
+// handler registered for its MEI type.
+func (s *Server) EncapsulatedInterfaceTransport(ctx context.Context, request *Request) ([]byte, *Exception) {
+	data := request.Frame().GetData()
+	if len(data) < 1 {
+		return []byte{}, &IllegalDataValue
+	}
+	handler := s.mei[data[0]]
+	if handler == nil {
+		return []byte{}, &IllegalFunction
+	}
+	return handler.ServeModbus(ctx, request)
+}
+
+// ReadDeviceIdentification function 43 MEI type 14, reads the device
+// identification objects of a slave using stream or individual access.
+func ReadDeviceIdentification(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) != 3 {
+		return []byte{}, &IllegalDataValue
+	}
+	code := data[1]
+	objectID := data[2]
+	if code < readDeviceIDBasic || code > readDeviceIDIndividual {
+		return []byte{}, &IllegalDataValue
+	}
+
+	slaveID := frame.GetAddress()
+	idx := s.upperSlaveId - slaveID
+	ids, objects := s.slaves[idx].deviceIDObjects()
+
+	// MEI type, read device ID code, conformity level, more follows,
+	// next object ID and number of objects.
+	response := []byte{0x0e, code, deviceIDConformityLevel(ids), 0, 0, 0}
+
+	if code == readDeviceIDIndividual {
+		value, ok := objects[objectID]
+		if !ok {
+			return []byte{}, &IllegalDataAddress
+		}
+		response[5] = 1
+		response = append(response, objectID, byte(len(value)))
+		return append(response, value...), &Success
+	}
+
+	// Stream access restarts at the first object if the requested object
+	// does not exist in the category.
+	limit := deviceIDCategoryLimit(code)
+	if _, ok := objects[objectID]; !ok || objectID > limit {
+		objectID = DeviceIDVendorName
+	}
+	size := 0
+	for _, id := range ids {
+		if id < objectID || id > limit {
+			continue
+		}
+		value := objects[id]
+		if size+2+len(value) > maxDeviceIDObjectsSize {
+			// The remaining objects are returned in a following transaction.
+			response[3] = 0xff
+			response[4] = id
+			break
+		}
+		size += 2 + len(value)
+		response[5]++
+		response = append(response, id, byte(len(value)))
+		response = append(response, value...)
+	}
+
+	return response, &Success
+}
+
 // BytesToUint16 converts a big endian array of bytes to an array of unit16s
 func BytesToUint16(bytes []byte) []uint16 {
 	values := make([]uint16, len(bytes)/2)
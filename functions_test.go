@@ -1,6 +1,7 @@
 package mbserver
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 )
@@ -203,6 +204,202 @@ func TestWriteHoldingRegister(t *testing.T) {
 	}
 }
 
+// Function 7
+func TestReadExceptionStatus(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.SetExceptionStatus(255, 0x6d)
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 7
+	frame.SetData([]byte{})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{0x6d}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// Bound to coils.
+	s.BindExceptionStatus(255, [8]uint16{10, 11, 12, 13, 14, 15, 16, 100})
+	s.slaves[0].Coils[11] = 1
+	s.slaves[0].Coils[100] = 1
+	frame.SetData([]byte{})
+	response = s.handle(&req)
+	expect = []byte{0x82}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+}
+
+// Function 8
+func TestDiagnostics(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	var frame RTUFrame
+	frame.Address = 255
+	frame.Function = 8
+	frame.SetData([]byte{0, 0, 0xa5, 0x37})
+
+	var req Request
+	req.frame = &frame
+	req.diagnostics = newDiagnostics()
+
+	// Return Query Data
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{0, 0, 0xa5, 0x37}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// An exception response is counted.
+	frame.Function = 8
+	frame.SetData([]byte{0, 0x0b, 0, 1})
+	s.handle(&req)
+
+	// Return Bus Exception Error Count
+	frame.Function = 8
+	frame.SetData([]byte{0, 0x0d, 0, 0})
+	response = s.handle(&req)
+	expect = []byte{0, 0x0d, 0, 1}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// Force Listen Only Mode suppresses all responses.
+	frame.SetData([]byte{0, 0x04, 0, 0})
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+
+	// Restart Communications Option leaves listen only mode and clears the
+	// counters.
+	frame.Function = 8
+	frame.SetData([]byte{0, 0x01, 0, 0})
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+	frame.SetData([]byte{0, 0x0e, 0, 0})
+	response = s.handle(&req)
+	if response == nil {
+		t.Fatalf("expected response after restart")
+	}
+	// Return Server Message Count
+	expect = []byte{0, 0x0e, 0, 1}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// Diagnostics are not available without a serial port.
+	req.diagnostics = nil
+	frame.Function = 8
+	frame.SetData([]byte{0, 0, 0, 0})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception.String())
+	}
+}
+
+// Function 11 and 12
+func TestGetCommEventLog(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	var frame RTUFrame
+	frame.Address = 255
+
+	var req Request
+	req.frame = &frame
+	req.diagnostics = newDiagnostics()
+	req.diagnostics.increment(busMessageCount)
+
+	// A successful write and a read exception.
+	frame.Function = 6
+	SetDataWithRegisterAndNumber(&frame, 1, 2)
+	s.handle(&req)
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 65535, 2)
+	s.handle(&req)
+
+	frame.Function = 11
+	frame.SetData([]byte{})
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{0, 0, 0, 1}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	frame.Function = 12
+	frame.SetData([]byte{})
+	response = s.handle(&req)
+	// Most recent event first: receive of this request, send and receive of
+	// function 11, exception send and receive of function 3, send and receive
+	// of function 6.
+	expect = []byte{13, 0, 0, 0, 1, 0, 1, 0x80, 0x40, 0x80, 0x41, 0x80, 0x40, 0x80}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// Restart Communications Option with log clear.
+	frame.Function = 8
+	frame.SetData([]byte{0, 0x01, 0xff, 0})
+	s.handle(&req)
+	frame.Function = 12
+	frame.SetData([]byte{})
+	response = s.handle(&req)
+	expect = []byte{9, 0, 0, 0, 1, 0, 0, 0x80, 0x40, 0x00}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// The handlers read the diagnostics of the request they are given.
+	other := Request{frame: &RTUFrame{Address: 255, Function: 11}, diagnostics: newDiagnostics()}
+	other.diagnostics.incrementEventCounter()
+	data, handled := s.Handler(11).ServeModbus(context.Background(), &other)
+	if handled != &Success {
+		t.Fatalf("expected Success, got %v", handled.String())
+	}
+	expect = []byte{0, 0, 0, 1}
+	if !isEqual(expect, data) {
+		t.Errorf("expected %v, got %v\n", expect, data)
+	}
+}
+
 // Function 15
 func TestWriteMultipleCoils(t *testing.T) {
 	var LowerID, UpperID byte = 255, 255
@@ -259,6 +456,415 @@ func TestWriteHoldingRegisters(t *testing.T) {
 	}
 }
 
+// Function 17
+func TestReportServerID(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 17
+	frame.SetData([]byte{})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{2, 255, 0xff}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	if err := s.SetServerID(1, []byte{0x12}, true, nil); err == nil {
+		t.Errorf("expected error for slave out of range")
+	}
+	if err := s.SetServerID(255, []byte{0x12, 0x34}, false, []byte("v1")); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	frame.SetData([]byte{})
+	response = s.handle(&req)
+	expect = []byte{5, 0x12, 0x34, 0x00, 'v', '1'}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+}
+
+// Function 20
+func TestReadFileRecord(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.slaves[0].SetFileRecords(4, 1, 0x0df5, 0x1f0e)
+	s.slaves[0].SetFileRecords(3, 9, 0x0f33, 0x0488, 0x0626)
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 20
+	// Example from the Modbus application protocol specification.
+	frame.SetData([]byte{0x0e, 6, 0, 4, 0, 1, 0, 2, 6, 0, 3, 0, 9, 0, 2})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{0x0c, 0x05, 6, 0x0d, 0xf5, 0x1f, 0x0e, 0x05, 6, 0x0f, 0x33, 0x04, 0x88}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// Record beyond the end of the file.
+	frame.Function = 20
+	frame.SetData([]byte{0x07, 6, 0, 4, 0, 2, 0, 2})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+
+	// Invalid reference type.
+	frame.Function = 20
+	frame.SetData([]byte{0x07, 5, 0, 4, 0, 1, 0, 1})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+
+	// Byte count does not match the sub-requests.
+	frame.Function = 20
+	frame.SetData([]byte{0x0e, 6, 0, 4, 0, 1, 0, 1})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+}
+
+// Function 21
+func TestWriteFileRecord(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.slaves[0].SetFileRecords(4, 0, make([]uint16, 10)...)
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 21
+	// Example from the Modbus application protocol specification.
+	request := []byte{0x0d, 6, 0, 4, 0, 7, 0, 3, 0x06, 0xaf, 0x04, 0xbe, 0x10, 0x0d}
+	frame.SetData(request)
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	got := response.GetData()
+	if !isEqual(request, got) {
+		t.Errorf("expected %v, got %v\n", request, got)
+	}
+	expectValues := []uint16{0x06af, 0x04be, 0x100d}
+	gotValues, err := s.slaves[0].FileRecords(4, 7, 3)
+	if err != nil || !isEqual(expectValues, gotValues) {
+		t.Errorf("expected %v, got %v (%v)\n", expectValues, gotValues, err)
+	}
+
+	// The second sub-request is invalid, so nothing is written.
+	frame.Function = 21
+	frame.SetData([]byte{0x12, 6, 0, 4, 0, 0, 0, 1, 0, 1, 6, 0, 5, 0, 0, 0, 1, 0, 1})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+	gotValues, _ = s.slaves[0].FileRecords(4, 0, 1)
+	if !isEqual([]uint16{0}, gotValues) {
+		t.Errorf("expected [0], got %v\n", gotValues)
+	}
+}
+
+// Function 22
+func TestMaskWriteRegister(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.slaves[0].HoldingRegisters[30004] = 0x12
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 22
+	// Example from the Modbus application protocol specification.
+	frame.SetData([]byte{0x75, 0x34, 0, 0xf2, 0, 0x25})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{0x75, 0x34, 0, 0xf2, 0, 0x25}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+	expectValues := []uint16{0x17, 0x17}
+	gotValues := []uint16{s.slaves[0].HoldingRegisters[30004], s.slaves[0].InputRegisters[4]}
+	if !isEqual(expectValues, gotValues) {
+		t.Errorf("expected %v, got %v\n", expectValues, gotValues)
+	}
+}
+
+// Function 23
+func TestReadWriteMultipleRegisters(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.slaves[0].HoldingRegisters[30001] = 7
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 23
+	// Read 30000..30002, write 30000..30001.
+	frame.SetData([]byte{0x75, 0x30, 0, 3, 0x75, 0x30, 0, 2, 4, 0, 5, 0, 6})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	// The write is performed before the read.
+	expect := []byte{6, 0, 5, 0, 6, 0, 0}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+	expectIR := []uint16{5, 6}
+	gotIR := s.slaves[0].InputRegisters[0:2]
+	if !isEqual(expectIR, gotIR) {
+		t.Errorf("expected %v, got %v\n", expectIR, gotIR)
+	}
+
+	// Byte count does not match the write quantity.
+	frame.Function = 23
+	frame.SetData([]byte{0, 0, 0, 1, 0, 0, 0, 2, 2, 0, 5})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+
+	frame.SetData([]byte{255, 255, 0, 2, 0, 0, 0, 1, 2, 0, 5})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+}
+
+// Function 24
+func TestReadFIFOQueue(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	err := s.slaves[0].PushFIFO(0x04de, 0x01b8, 0x1284)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 24
+	frame.SetData([]byte{0x04, 0xde})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{0, 6, 0, 2, 0x01, 0xb8, 0x12, 0x84}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	frame.Function = 24
+	frame.SetData([]byte{0, 1})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+}
+
+// Function 43, MEI type 14
+func TestReadDeviceIdentification(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.slaves[0].SetDeviceIdentification(DeviceIDVendorName, "ACME")
+	s.slaves[0].SetDeviceIdentification(DeviceIDProductCode, "X1")
+	s.slaves[0].SetDeviceIdentification(DeviceIDMajorMinorRevision, "1.0")
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 43
+	frame.SetData([]byte{0x0e, 1, 0})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{0x0e, 1, 0x81, 0, 0, 3, 0, 4, 'A', 'C', 'M', 'E', 1, 2, 'X', '1', 2, 3, '1', '.', '0'}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	// Individual access.
+	frame.Function = 43
+	frame.SetData([]byte{0x0e, 4, 1})
+	response = s.handle(&req)
+	expect = []byte{0x0e, 4, 0x81, 0, 0, 1, 1, 2, 'X', '1'}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+
+	frame.Function = 43
+	frame.SetData([]byte{0x0e, 4, 0x80})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+
+	// Objects that do not fit one PDU are continued with more follows.
+	long := string(make([]byte, 200))
+	s.slaves[0].SetDeviceIdentification(0x80, long)
+	s.slaves[0].SetDeviceIdentification(0x81, long)
+	frame.Function = 43
+	frame.SetData([]byte{0x0e, 3, 0})
+	response = s.handle(&req)
+	got = response.GetData()
+	if got[2] != 0x83 || got[3] != 0xff || got[4] != 0x81 || got[5] != 4 {
+		t.Errorf("expected continuation at object 0x81, got %v\n", got[:6])
+	}
+	frame.Function = 43
+	frame.SetData([]byte{0x0e, 3, got[4]})
+	response = s.handle(&req)
+	got = response.GetData()
+	if got[3] != 0 || got[5] != 1 || got[6] != 0x81 {
+		t.Errorf("expected last object 0x81, got %v\n", got[:7])
+	}
+
+	frame.Function = 43
+	frame.SetData([]byte{0x0e, 5, 0})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+}
+
+// Function 43
+func TestEncapsulatedInterfaceTransport(t *testing.T) {
+	var LowerID, UpperID byte = 255, 255
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	var roles []string
+	s.RegisterMEIHandler(13, HandlerFunc(func(ctx context.Context, request *Request) ([]byte, *Exception) {
+		roles = append(roles, request.Role())
+		return append(request.Frame().GetData(), 1), &Success
+	}))
+
+	// MEI handlers are called through the middleware.
+	s.Use(func(next Responder) Responder {
+		return ResponderFunc(func(ctx context.Context, request *Request) Framer {
+			response := next.Respond(ctx, request)
+			if request.Frame().GetFunction() == 43 && GetException(response) == Success {
+				response.SetData(append(response.GetData(), 2))
+			}
+			return response
+		})
+	})
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 255
+	frame.Function = 43
+	frame.SetData([]byte{13, 0})
+
+	var req Request
+	req.frame = &frame
+	req.role = "Operator"
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expect := []byte{13, 0, 1, 2}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v\n", expect, got)
+	}
+	expectRoles := []string{"Operator"}
+	if !isEqual(expectRoles, roles) {
+		t.Errorf("expected %v, got %v", expectRoles, roles)
+	}
+
+	// Device identification remains registered.
+	frame.Function = 43
+	frame.SetData([]byte{0x0e, 1, 0})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+	}
+
+	frame.Function = 43
+	frame.SetData([]byte{12})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception.String())
+	}
+}
+
 func TestBytesToUint16(t *testing.T) {
 	bytes := []byte{1, 2, 3, 4}
 	got := BytesToUint16(bytes)
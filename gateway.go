@@ -0,0 +1,92 @@
+package mbserver
+
+import "time"
+
+// Forwarder forwards requests to remote devices, such as the devices of an
+// RS-485 bus or Modbus TCP servers. A client.Client is a Forwarder.
+type Forwarder interface {
+	// Forward sends a request, keeping its unit ID, function and data, and
+	// returns the response, exception responses included. An error
+	// implementing Timeout() bool, returning true, reports a device which
+	// failed to respond; other errors report an unavailable path. Forward is
+	// called by several goroutines.
+	Forward(request Framer) (Framer, error)
+}
+
+// SerialRoute forwards the requests for a unit ID received on serial lines.
+type SerialRoute struct {
+	// Forwarder of the requests, such as a client.Client connected to a
+	// Modbus TCP server.
+	Forwarder Forwarder
+	// UnitID of the forwarded requests. The response is relayed with the
+	// unit ID received.
+	UnitID byte
+}
+
+// SetGateway makes the server a gateway: requests received over TCP or UDP
+// for unit IDs outside the slave range are forwarded and the responses
+// relayed back. They are forwarded concurrently with the requests handled by
+// the server, a client.Client sends them one at a time over its bus. Requests
+// received on serial lines are only forwarded by serial routes, as they are
+// otherwise addressed to the other devices of the line. A nil forwarder
+// disables the gateway.
+func (s *Server) SetGateway(forwarder Forwarder) {
+	s.gateway = forwarder
+}
+
+// gatewayRequest returns true if a request is forwarded by the gateway.
+func (s *Server) gatewayRequest(request *Request, route *Route) bool {
+	slaveID := request.frame.GetAddress()
+	return s.gateway != nil && !request.transport.serial() && route == nil &&
+		slaveID != 0 && (slaveID < s.lowerSlaveId || slaveID > s.upperSlaveId)
+}
+
+// SetSerialRoute makes the server a reverse gateway for a unit ID: requests
+// for it received on serial lines are forwarded and answered with the
+// response relayed back. They are forwarded concurrently with the requests
+// handled by the server, so a slow device does not hold up the others. A
+// route takes precedence over a slave with the same unit ID. A nil route
+// removes the route of the unit ID.
+func (s *Server) SetSerialRoute(unitID byte, route *SerialRoute) {
+	s.serialRoutes[unitID] = route
+}
+
+// serialRoute returns the serial route of a request, nil if the request was
+// not received on a serial line or its unit ID has no route.
+func (s *Server) serialRoute(request *Request) *SerialRoute {
+	unitID := request.frame.GetAddress()
+	if !request.transport.serial() || unitID == 0 {
+		return nil
+	}
+	return s.serialRoutes[unitID]
+}
+
+// forward sends a request to a forwarder, with a timeout if not 0. It
+// returns a nil exception if no response is to be sent.
+func forward(forwarder Forwarder, frame Framer, timeout time.Duration) ([]byte, *Exception) {
+	var remote Framer
+	var err error
+	if timeoutForwarder, ok := forwarder.(TimeoutForwarder); ok && timeout > 0 {
+		remote, err = timeoutForwarder.ForwardTimeout(frame, timeout)
+	} else {
+		remote, err = forwarder.Forward(frame)
+	}
+	switch {
+	case err != nil:
+		return []byte{}, gatewayException(err)
+	case remote == nil:
+		return nil, nil
+	case GetException(remote) != Success:
+		exception := GetException(remote)
+		return []byte{}, &exception
+	}
+	return remote.GetData(), &Success
+}
+
+// gatewayException returns the exception reporting a forwarding error.
+func gatewayException(err error) *Exception {
+	if timeout, ok := err.(interface{ Timeout() bool }); ok && timeout.Timeout() {
+		return &GatewayTargetDeviceFailedtoRespond
+	}
+	return &GatewayPathUnavailable
+}
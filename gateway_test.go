@@ -0,0 +1,222 @@
+package mbserver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+// fakeForwarder answers requests with a fixed response or error.
+type fakeForwarder struct {
+	requests []Framer
+	response Framer
+	err      error
+}
+
+func (f *fakeForwarder) Forward(request Framer) (Framer, error) {
+	f.requests = append(f.requests, request)
+	return f.response, f.err
+}
+
+// blockingForwarder answers requests once released.
+type blockingForwarder struct {
+	release chan struct{}
+}
+
+func (f *blockingForwarder) Forward(request Framer) (Framer, error) {
+	<-f.release
+	response := request.Copy()
+	response.SetData([]byte{2, 0, 1})
+	return response, nil
+}
+
+func TestGateway(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	forwarder := &fakeForwarder{}
+	s.SetGateway(forwarder)
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 7
+	frame.Device = 5
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	req := Request{frame: &frame}
+
+	forwarder.response = &RTUFrame{Address: 5, Function: 3, Data: []byte{2, 0x12, 0x34}}
+	response := s.handle(&req)
+	if response == nil {
+		t.Fatalf("expected response, got nil")
+	}
+	expect := []byte{0, 7, 0, 0, 0, 5, 5, 3, 2, 0x12, 0x34}
+	got := response.Bytes()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	tests := []struct {
+		response Framer
+		err      error
+		expect   Exception
+	}{
+		{&RTUFrame{Address: 5, Function: 0x83, Data: []byte{2}}, nil, IllegalDataAddress},
+		{nil, timeoutError{}, GatewayTargetDeviceFailedtoRespond},
+		{nil, errors.New("closed"), GatewayPathUnavailable},
+	}
+	for _, test := range tests {
+		forwarder.response, forwarder.err = test.response, test.err
+		response = s.handle(&req)
+		if response == nil {
+			t.Fatalf("expected response, got nil")
+		}
+		if exception := GetException(response); exception != test.expect {
+			t.Errorf("expected %v, got %v", test.expect, exception)
+		}
+	}
+
+	// Requests received on a serial line are not forwarded.
+	count := len(forwarder.requests)
+	rtuFrame := RTUFrame{Address: 5, Function: 3, Data: frame.Data}
+	req = Request{frame: &rtuFrame, diagnostics: newDiagnostics(), transport: TransportRTU}
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+	if len(forwarder.requests) != count {
+		t.Errorf("expected %v forwarded requests, got %v", count, len(forwarder.requests))
+	}
+}
+
+func TestGatewayConcurrent(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+	forwarder := &blockingForwarder{release: make(chan struct{})}
+	s.SetGateway(forwarder)
+	err := s.ListenTCP("127.0.0.1:3346")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:3346")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// A local request is answered while the device behind the gateway does
+	// not respond.
+	forwarded := &TCPFrame{TransactionIdentifier: 1, Device: 5, Function: 3}
+	SetDataWithRegisterAndNumber(forwarded, 0, 1)
+	local := &TCPFrame{TransactionIdentifier: 2, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(local, 0, 1)
+	conn.Write(append(forwarded.Bytes(), local.Bytes()...))
+
+	for _, expect := range [][]byte{
+		{0, 2, 0, 0, 0, 5, 1, 3, 2, 0, 0},
+		{0, 1, 0, 0, 0, 5, 5, 3, 2, 0, 1},
+	} {
+		got := make([]byte, len(expect))
+		_, err := io.ReadFull(conn, got)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if !isEqual(expect, got) {
+			t.Errorf("expected %v, got %v", expect, got)
+		}
+		select {
+		case <-forwarder.release:
+		default:
+			close(forwarder.release)
+		}
+	}
+}
+
+func TestSerialRoute(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	forwarder := &fakeForwarder{}
+	s.SetSerialRoute(7, &SerialRoute{Forwarder: forwarder, UnitID: 0xff})
+
+	frame := RTUFrame{Address: 7, Function: 3}
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	req := Request{frame: &frame, diagnostics: newDiagnostics(), transport: TransportRTU}
+
+	forwarder.response = &TCPFrame{Device: 0xff, Function: 3, Data: []byte{2, 0x12, 0x34}}
+	response := s.handle(&req)
+	if response == nil {
+		t.Fatalf("expected response, got nil")
+	}
+	if len(forwarder.requests) != 1 || forwarder.requests[0].GetAddress() != 0xff {
+		t.Errorf("expected request forwarded to unit 255, got %v", forwarder.requests)
+	}
+	expect := (&RTUFrame{Address: 7, Function: 3, Data: []byte{2, 0x12, 0x34}}).Bytes()
+	got := response.Bytes()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if count := req.diagnostics.counter(serverMessageCount); count != 1 {
+		t.Errorf("expected 1 message, got %v", count)
+	}
+
+	forwarder.response, forwarder.err = nil, timeoutError{}
+	response = s.handle(&req)
+	if exception := GetException(response); exception != GatewayTargetDeviceFailedtoRespond {
+		t.Errorf("expected %v, got %v", GatewayTargetDeviceFailedtoRespond, exception)
+	}
+
+	// Requests received over TCP are not routed.
+	tcpFrame := TCPFrame{Device: 7, Function: 3, Data: frame.Data}
+	req = Request{frame: &tcpFrame}
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+
+	s.SetSerialRoute(7, nil)
+	req = Request{frame: &frame, diagnostics: newDiagnostics(), transport: TransportRTU}
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+	if len(forwarder.requests) != 2 {
+		t.Errorf("expected 2 forwarded requests, got %v", len(forwarder.requests))
+	}
+}
+
+func TestSerialRouteConcurrent(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+	forwarder := &blockingForwarder{release: make(chan struct{})}
+	s.SetSerialRoute(7, &SerialRoute{Forwarder: forwarder, UnitID: 1})
+
+	routed, routedClient := net.Pipe()
+	go s.ServeRTU(routed, RTUOptions{FrameSilence: 20 * time.Millisecond})
+	local, localClient := net.Pipe()
+	go s.ServeRTU(local, RTUOptions{FrameSilence: 20 * time.Millisecond})
+
+	// A request on another port is answered while the routed device does
+	// not respond.
+	request := RTUFrame{Address: 7, Function: 3, Data: []byte{0, 0, 0, 1}}
+	routedClient.Write(request.Bytes())
+	time.Sleep(10 * time.Millisecond)
+	request.Address = 1
+	localClient.Write(request.Bytes())
+
+	response := RTUFrame{Address: 1, Function: 3, Data: []byte{2, 0, 0}}
+	expect := response.Bytes()
+	got := readRTUResponse(t, localClient, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	close(forwarder.release)
+	response = RTUFrame{Address: 7, Function: 3, Data: []byte{2, 0, 1}}
+	expect = response.Bytes()
+	got = readRTUResponse(t, routedClient, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,144 @@
+package mbserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Transport is the transport a request was received on.
+type Transport int
+
+const (
+	// TransportTCP Modbus TCP.
+	TransportTCP Transport = iota
+	// TransportTLS Modbus/TCP Security.
+	TransportTLS
+	// TransportUDP Modbus TCP frames carried in UDP datagrams.
+	TransportUDP
+	// TransportRTU RTU frames on a serial line or stream.
+	TransportRTU
+	// TransportASCII ASCII frames on a serial line.
+	TransportASCII
+	// TransportRTUOverTCP RTU frames carried over TCP.
+	TransportRTUOverTCP
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportTCP:
+		return "TCP"
+	case TransportTLS:
+		return "TLS"
+	case TransportUDP:
+		return "UDP"
+	case TransportRTU:
+		return "RTU"
+	case TransportASCII:
+		return "ASCII"
+	case TransportRTUOverTCP:
+		return "RTUOverTCP"
+	}
+	return fmt.Sprintf("Transport(%d)", int(t))
+}
+
+// serial returns true for the transports of serial lines, which requests are
+// addressed to the devices of the line.
+func (t Transport) serial() bool {
+	return t == TransportRTU || t == TransportASCII
+}
+
+// Handler handles the requests of a Modbus function. The context is done
+// when the server is closed or the request timeout expires.
+type Handler interface {
+	ServeModbus(ctx context.Context, request *Request) ([]byte, *Exception)
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(ctx context.Context, request *Request) ([]byte, *Exception)
+
+// ServeModbus calls f(ctx, request).
+func (f HandlerFunc) ServeModbus(ctx context.Context, request *Request) ([]byte, *Exception) {
+	return f(ctx, request)
+}
+
+// RegisterHandler sets the handler of a Modbus function, a nil handler removes
+// the function.
+func (s *Server) RegisterHandler(funcCode uint8, handler Handler) {
+	s.handlers[funcCode] = handler
+}
+
+// Handler returns the handler of a Modbus function, or nil.
+func (s *Server) Handler(funcCode uint8) Handler {
+	return s.handlers[funcCode]
+}
+
+// FunctionHandler adapts a function with the RegisterFunctionHandler
+// signature to a Handler of the server.
+func (s *Server) FunctionHandler(function func(*Server, Framer) ([]byte, *Exception)) Handler {
+	return HandlerFunc(func(ctx context.Context, request *Request) ([]byte, *Exception) {
+		return function(s, request.Frame())
+	})
+}
+
+// newConnID returns the ID of a new connection, serial port or UDP sender.
+func (s *Server) newConnID() uint64 {
+	return atomic.AddUint64(&s.connIDs, 1)
+}
+
+// Frame returns the Modbus frame of the request. The unit ID is that of the
+// slave the request is executed on, for broadcasts and requests for unit ID
+// 0 over TCP as well.
+func (r *Request) Frame() Framer {
+	return r.frame
+}
+
+// Transport returns the transport the request was received on.
+func (r *Request) Transport() Transport {
+	return r.transport
+}
+
+// RemoteAddr returns the address of the client, nil on serial lines.
+func (r *Request) RemoteAddr() net.Addr {
+	return r.remoteAddr
+}
+
+// ConnID returns the ID of the connection or serial port the request was
+// received on, or of its UDP sender, unique within the server.
+func (r *Request) ConnID() uint64 {
+	return r.connID
+}
+
+// TLS returns the state of the TLS connection the request was received on,
+// including the certificates of the client, or nil.
+func (r *Request) TLS() *tls.ConnectionState {
+	return r.tlsState
+}
+
+// Received returns the time the request was received.
+func (r *Request) Received() time.Time {
+	return r.received
+}
+
+// WithFrame returns a copy of the request with another frame, for middleware
+// altering requests.
+func (r *Request) WithFrame(frame Framer) *Request {
+	if frame == r.frame {
+		return r
+	}
+	request := *r
+	request.frame = frame
+	return &request
+}
+
+// withRoute returns a copy of the request with another frame, forwarded or
+// rejected by a route.
+func (r *Request) withRoute(frame Framer, route *Route) *Request {
+	request := *r
+	request.frame = frame
+	request.route = route
+	return &request
+}
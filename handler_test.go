@@ -0,0 +1,176 @@
+package mbserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandlerRequest(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	err := s.ListenTCP("127.0.0.1:3344")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	requests := make(chan *Request, 2)
+	s.RegisterHandler(3, HandlerFunc(func(ctx context.Context, request *Request) ([]byte, *Exception) {
+		requests <- request
+		return []byte{2, 0, 1}, &Success
+	}))
+
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	var connIDs []uint64
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:3344")
+		if err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		before := time.Now()
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+		conn.Write(frame.Bytes())
+		response := make([]byte, 512)
+		_, err = conn.Read(response)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+
+		request := <-requests
+		if request.Transport() != TransportTCP {
+			t.Errorf("expected %v, got %v", TransportTCP, request.Transport())
+		}
+		if request.RemoteAddr().String() != conn.LocalAddr().String() {
+			t.Errorf("expected %v, got %v", conn.LocalAddr(), request.RemoteAddr())
+		}
+		if request.TLS() != nil {
+			t.Errorf("expected no TLS state, got %v", request.TLS())
+		}
+		if request.Received().Before(before) {
+			t.Errorf("expected receive time after %v, got %v", before, request.Received())
+		}
+		if request.Frame().GetFunction() != 3 {
+			t.Errorf("expected function 3, got %v", request.Frame().GetFunction())
+		}
+		connIDs = append(connIDs, request.ConnID())
+	}
+
+	if connIDs[0] == connIDs[1] {
+		t.Errorf("expected different connection IDs, got %v", connIDs)
+	}
+}
+
+func TestHandlerRequestUDP(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	requests := make(chan *Request, 4)
+	s.RegisterHandler(3, HandlerFunc(func(ctx context.Context, request *Request) ([]byte, *Exception) {
+		requests <- request
+		return []byte{2, 0, 1}, &Success
+	}))
+
+	err := s.ListenUDP("127.0.0.1:3349")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Two requests from each of two senders.
+	var connIDs []uint64
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("udp", "127.0.0.1:3349")
+		if err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		for j := 0; j < 2; j++ {
+			frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+			SetDataWithRegisterAndNumber(frame, 0, 1)
+			conn.Write(frame.Bytes())
+			response := make([]byte, 512)
+			_, err = conn.Read(response)
+			if err != nil {
+				t.Fatalf("expected nil, got %v\n", err)
+			}
+
+			request := <-requests
+			if request.Transport() != TransportUDP {
+				t.Errorf("expected %v, got %v", TransportUDP, request.Transport())
+			}
+			connIDs = append(connIDs, request.ConnID())
+		}
+	}
+
+	if connIDs[0] != connIDs[1] || connIDs[2] != connIDs[3] {
+		t.Errorf("expected one connection ID per sender, got %v", connIDs)
+	}
+	if connIDs[0] == connIDs[2] {
+		t.Errorf("expected different connection IDs, got %v", connIDs)
+	}
+}
+
+func TestFunctionHandlerAdapter(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	s.RegisterHandler(4, s.Handler(3))
+
+	var frame TCPFrame
+	frame.Device = 1
+	frame.Function = 4
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	s.slaves[0].HoldingRegisters[0] = 42
+	response := s.handle(&Request{frame: &frame})
+	expect := []byte{2, 0, 42}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	s.RegisterFunctionHandler(4, nil)
+	if s.Handler(4) != nil {
+		t.Errorf("expected no handler, got %v", s.Handler(4))
+	}
+	response = s.handle(&Request{frame: &frame})
+	if exception := GetException(response); exception != IllegalFunction {
+		t.Errorf("expected %v, got %v", IllegalFunction, exception)
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	s.RequestTimeout = time.Second
+
+	var ctx context.Context
+	s.RegisterHandler(3, HandlerFunc(func(handlerCtx context.Context, request *Request) ([]byte, *Exception) {
+		ctx = handlerCtx
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("expected deadline")
+		}
+		return []byte{2, 0, 0}, &Success
+	}))
+
+	var frame TCPFrame
+	frame.Device = 1
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	s.handle(&Request{frame: &frame})
+
+	// The context is done once the request is handled.
+	if ctx == nil || ctx.Err() == nil {
+		t.Errorf("expected done context, got %v", ctx)
+	}
+
+	s.Close()
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	if ctx.Err() == nil {
+		t.Errorf("expected context done once the server is closed")
+	}
+}
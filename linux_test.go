@@ -4,6 +4,7 @@
 package mbserver
 
 import (
+	"fmt"
 	"log"
 	"os/exec"
 	"testing"
@@ -100,3 +101,153 @@ func TestModbusRTU(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestModbusASCII(t *testing.T) {
+	if _, err := exec.LookPath("socat"); err != nil {
+		t.Skip("socat not found")
+	}
+
+	var nameSerial1, nameSerial2 string = "/tmp/ttyASCII1", "/tmp/ttyASCII2"
+
+	// Create a pair of virtual serial devices.
+	cmd := exec.Command("socat",
+		"pty,raw,echo=0,link="+nameSerial1,
+		"pty,raw,echo=0,link="+nameSerial2,
+	)
+	err := cmd.Start()
+	if err != nil {
+		t.Fatalf("socat not start %v", err)
+	}
+
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// Allow the virtual serial devices to be created.
+	time.Sleep(100 * time.Millisecond)
+
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	err = s.ListenASCII(nameSerial1,
+		&serial.Mode{BaudRate: 9600,
+			DataBits: 7,
+			Parity:   serial.EvenParity,
+			StopBits: serial.OneStopBit,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Client
+	handler := modbus.NewASCIIClientHandler(nameSerial2)
+	handler.BaudRate = 9600
+	handler.DataBits = 7
+	handler.Parity = "E"
+	handler.StopBits = 1
+	handler.SlaveId = 1
+	handler.Timeout = 5 * time.Second
+	err = handler.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+
+	client := modbus.NewClient(handler)
+
+	_, err = client.WriteMultipleRegisters(1, 2, []byte{0, 3, 0, 4})
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	results, err := client.ReadHoldingRegisters(1, 2)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	expect := []byte{0, 3, 0, 4}
+	got := results
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestModbusRTUMultiplePorts(t *testing.T) {
+	if _, err := exec.LookPath("socat"); err != nil {
+		t.Skip("socat not found")
+	}
+
+	// Create two pairs of virtual serial devices.
+	names := [][2]string{
+		{"/tmp/ttyMULTI1", "/tmp/ttyMULTI1C"},
+		{"/tmp/ttyMULTI2", "/tmp/ttyMULTI2C"},
+	}
+	for _, name := range names {
+		cmd := exec.Command("socat",
+			"pty,raw,echo=0,link="+name[0],
+			"pty,raw,echo=0,link="+name[1],
+		)
+		err := cmd.Start()
+		if err != nil {
+			t.Fatalf("socat not start %v", err)
+		}
+		defer cmd.Wait()
+		defer cmd.Process.Kill()
+	}
+
+	// Allow the virtual serial devices to be created.
+	time.Sleep(100 * time.Millisecond)
+
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	for _, name := range names {
+		err := s.ListenRTU(name[0], &serial.Mode{BaudRate: 115200})
+		if err != nil {
+			t.Fatalf("failed to listen, got %v\n", err)
+		}
+	}
+	defer s.Close()
+
+	// Clients on both ports write and read back their own registers at the
+	// same time.
+	errs := make(chan error, len(names))
+	for i, name := range names {
+		go func(register uint16, name string) {
+			handler := modbus.NewRTUClientHandler(name)
+			handler.BaudRate = 115200
+			handler.SlaveId = 1
+			handler.Timeout = 5 * time.Second
+			err := handler.Connect()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer handler.Close()
+			client := modbus.NewClient(handler)
+
+			for value := uint16(0); value < 50; value++ {
+				_, err = client.WriteSingleRegister(register, value)
+				if err != nil {
+					errs <- err
+					return
+				}
+				results, err := client.ReadHoldingRegisters(register, 1)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !isEqual([]byte{byte(value >> 8), byte(value)}, results) {
+					errs <- fmt.Errorf("port %s: expected %v, got %v", name, value, results)
+					return
+				}
+			}
+			errs <- nil
+		}(uint16(i), name[1])
+	}
+	for range names {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package mbserver
+
+import "context"
+
+// Responder returns the response frame of a request, nil if no response is
+// sent.
+type Responder interface {
+	Respond(ctx context.Context, request *Request) Framer
+}
+
+// ResponderFunc adapts an ordinary function to a Responder.
+type ResponderFunc func(ctx context.Context, request *Request) Framer
+
+// Respond calls f(ctx, request).
+func (f ResponderFunc) Respond(ctx context.Context, request *Request) Framer {
+	return f(ctx, request)
+}
+
+// Middleware wraps the dispatch of requests to the function handlers. A layer
+// may inspect or alter the request before calling next, answer it without
+// calling next, for example with an exception made by Request.Reply, or
+// post-process the response frame returned by next. A nil response frame
+// sends no response.
+type Middleware func(next Responder) Responder
+
+// Use adds layers of middleware around the dispatch of requests, including
+// broadcast requests and requests for functions without a handler. The first
+// layer added is the outermost. Requests forwarded by the gateway, serial
+// routes and upstream routes, and requests of reject routes, pass through the
+// middleware too, with the unit ID they are forwarded with; they are handled
+// by their own goroutines, so the middleware must be safe for concurrent use.
+// The innermost responder returns a nil frame for forwarded requests which
+// response is not expected.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+	chain := Responder(ResponderFunc(s.dispatch))
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		chain = s.middleware[i](chain)
+	}
+	s.chain = chain
+}
+
+// Reply returns a response frame to the request holding the data, or the
+// exception unless it is Success. The unit ID of the response is that of the
+// request received.
+func (r *Request) Reply(data []byte, exception *Exception) Framer {
+	response := r.frame.Copy()
+	response.SetData(data)
+	if exception != &Success {
+		response.SetException(exception)
+	}
+	return response
+}
+
+// dispatch calls the handler of the function of the request, or forwards the
+// request of a route, and returns the response frame.
+func (s *Server) dispatch(ctx context.Context, request *Request) Framer {
+	if request.route != nil {
+		data, exception := request.route.serve(request.frame)
+		if exception == nil {
+			// No response is expected.
+			return nil
+		}
+		return request.Reply(data, exception)
+	}
+	handler := s.handlers[request.frame.GetFunction()]
+	if handler == nil {
+		return request.Reply([]byte{}, &IllegalFunction)
+	}
+	data, exception := handler.ServeModbus(ctx, request)
+	if exception == nil {
+		exception = &Success
+	}
+	return request.Reply(data, exception)
+}
@@ -0,0 +1,143 @@
+package mbserver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	s.slaves[0].HoldingRegisters[0] = 1
+	s.slaves[0].HoldingRegisters[5] = 5
+
+	var calls []string
+	layer := func(name string) Middleware {
+		return func(next Responder) Responder {
+			return ResponderFunc(func(ctx context.Context, request *Request) Framer {
+				calls = append(calls, name)
+				return next.Respond(ctx, request)
+			})
+		}
+	}
+	s.Use(layer("audit"), layer("metrics"))
+
+	// Alter the request: register 0 is read from register 5.
+	s.Use(func(next Responder) Responder {
+		return ResponderFunc(func(ctx context.Context, request *Request) Framer {
+			register, numRegs, _, _ := registerAddressAndNumber(request.Frame())
+			if register != 0 {
+				return next.Respond(ctx, request)
+			}
+			frame := request.Frame().Copy()
+			SetDataWithRegisterAndNumber(frame, 5, numRegs)
+			return next.Respond(ctx, request.WithFrame(frame))
+		})
+	})
+
+	// Short-circuit writes, post-process the response frame of reads.
+	s.Use(func(next Responder) Responder {
+		return ResponderFunc(func(ctx context.Context, request *Request) Framer {
+			if request.Frame().GetFunction() == 6 {
+				return request.Reply([]byte{}, &IllegalDataAddress)
+			}
+			response := next.Respond(ctx, request)
+			if GetException(response) == Success {
+				data := append([]byte(nil), response.GetData()...)
+				data[len(data)-1]++
+				response.SetData(data)
+			}
+			return response
+		})
+	})
+
+	var frame TCPFrame
+	frame.Device = 1
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	response := s.handle(&Request{frame: &frame})
+	expect := []byte{2, 0, 6}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	expectCalls := []string{"audit", "metrics"}
+	if !isEqual(expectCalls, calls) {
+		t.Errorf("expected %v, got %v", expectCalls, calls)
+	}
+
+	frame.Function = 6
+	SetDataWithRegisterAndNumber(&frame, 1, 7)
+	response = s.handle(&Request{frame: &frame})
+	if exception := GetException(response); exception != IllegalDataAddress {
+		t.Errorf("expected %v, got %v", IllegalDataAddress, exception)
+	}
+	if s.slaves[0].HoldingRegisters[1] != 0 {
+		t.Errorf("expected the write to be short-circuited")
+	}
+
+	// Functions without a handler pass through the middleware.
+	calls = nil
+	frame.Function = 99
+	response = s.handle(&Request{frame: &frame})
+	if exception := GetException(response); exception != IllegalFunction {
+		t.Errorf("expected %v, got %v", IllegalFunction, exception)
+	}
+	if !isEqual(expectCalls, calls) {
+		t.Errorf("expected %v, got %v", expectCalls, calls)
+	}
+}
+
+func TestMiddlewareRoutes(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	response := &TCPFrame{Function: 3, Data: []byte{2, 0, 42}}
+	gateway := &fakeForwarder{response: response}
+	upstream := &fakeForwarder{response: response}
+	device := &fakeForwarder{response: response}
+	s.SetGateway(gateway)
+	s.SetSerialRoute(7, &SerialRoute{Forwarder: device, UnitID: 1})
+	err := s.SetRoutes([]Route{
+		{FirstUnitID: 10, LastUnitID: 10, Target: RouteUpstream, Upstream: upstream},
+		{FirstUnitID: 20, LastUnitID: 20, Target: RouteReject},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// Every path passes through the middleware, which may answer without
+	// forwarding.
+	var units []byte
+	s.Use(func(next Responder) Responder {
+		return ResponderFunc(func(ctx context.Context, request *Request) Framer {
+			units = append(units, request.Frame().GetAddress())
+			if request.Frame().GetFunction() == 6 {
+				return request.Reply([]byte{}, &IllegalFunction)
+			}
+			return next.Respond(ctx, request)
+		})
+	})
+
+	tests := []struct {
+		request   *Request
+		exception Exception
+	}{
+		{&Request{frame: &TCPFrame{Device: 1, Function: 3, Data: []byte{0, 0, 0, 1}}}, Success},
+		{&Request{frame: &TCPFrame{Device: 5, Function: 3, Data: []byte{0, 0, 0, 1}}}, Success},
+		{&Request{frame: &TCPFrame{Device: 10, Function: 3, Data: []byte{0, 0, 0, 1}}}, Success},
+		{&Request{frame: &TCPFrame{Device: 20, Function: 3, Data: []byte{0, 0, 0, 1}}}, GatewayPathUnavailable},
+		{&Request{frame: &RTUFrame{Address: 7, Function: 3, Data: []byte{0, 0, 0, 1}}, diagnostics: newDiagnostics(), transport: TransportRTU}, Success},
+		{&Request{frame: &TCPFrame{Device: 5, Function: 6, Data: []byte{0, 0, 0, 1}}}, IllegalFunction},
+	}
+	for _, test := range tests {
+		response := s.handle(test.request)
+		if exception := GetException(response); exception != test.exception {
+			t.Errorf("unit %v: expected %v, got %v", test.request.frame.GetAddress(), test.exception, exception)
+		}
+	}
+	expect := []byte{1, 5, 10, 20, 1, 5}
+	if !isEqual(expect, units) {
+		t.Errorf("expected %v, got %v", expect, units)
+	}
+	if len(gateway.requests) != 1 || len(upstream.requests) != 1 || len(device.requests) != 1 {
+		t.Errorf("expected 1 request forwarded by each, got %v %v %v", len(gateway.requests), len(upstream.requests), len(device.requests))
+	}
+}
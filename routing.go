@@ -0,0 +1,93 @@
+package mbserver
+
+import (
+	"fmt"
+	"time"
+)
+
+// RouteTarget selects how the requests of a route are handled.
+type RouteTarget int
+
+const (
+	// RouteLocal serves the requests from the slave memory. Requests for unit
+	// IDs outside the slave range are not answered.
+	RouteLocal RouteTarget = iota
+	// RouteUpstream forwards the requests to the upstream of the route.
+	RouteUpstream
+	// RouteReject answers the requests with GatewayPathUnavailable.
+	RouteReject
+)
+
+// Route maps a range of unit IDs of requests received over TCP or UDP.
+type Route struct {
+	FirstUnitID byte
+	LastUnitID  byte
+	Target      RouteTarget
+	// Upstream receiving the requests of a RouteUpstream route, such as a
+	// client.Pool of connections to a Modbus TCP server.
+	Upstream Forwarder
+	// Timeout of the forwarded requests, if the upstream is a
+	// TimeoutForwarder. The upstream's own timeout applies if 0.
+	Timeout time.Duration
+}
+
+// TimeoutForwarder is a Forwarder accepting a timeout for each request.
+type TimeoutForwarder interface {
+	ForwardTimeout(request Framer, timeout time.Duration) (Framer, error)
+}
+
+// SetRoutes sets the routing table of the requests received over TCP or UDP,
+// replacing the previous one. The first route matching a unit ID applies,
+// requests for unit IDs without a route are handled as without a routing
+// table. Modbus TCP requests of upstream routes are forwarded concurrently,
+// their responses may be sent out of order. The routes may be replaced while
+// the server is running.
+func (s *Server) SetRoutes(routes []Route) error {
+	var table [256]*Route
+	for i := range routes {
+		route := routes[i]
+		if route.FirstUnitID > route.LastUnitID {
+			return fmt.Errorf("route %d: unit IDs %d..%d out of order", i, route.FirstUnitID, route.LastUnitID)
+		}
+		if route.Target == RouteUpstream && route.Upstream == nil {
+			return fmt.Errorf("route %d: no upstream", i)
+		}
+		for unitID := int(route.FirstUnitID); unitID <= int(route.LastUnitID); unitID++ {
+			if table[unitID] == nil {
+				table[unitID] = &route
+			}
+		}
+	}
+	s.routesMutex.Lock()
+	s.routes = table
+	s.routesMutex.Unlock()
+	return nil
+}
+
+// route returns the route of a request received over TCP or UDP, or nil.
+func (s *Server) route(request *Request) *Route {
+	if request.transport.serial() {
+		return nil
+	}
+	s.routesMutex.Lock()
+	defer s.routesMutex.Unlock()
+	return s.routes[request.frame.GetAddress()]
+}
+
+// handleRoute handles a request of an upstream or reject route.
+func (s *Server) handleRoute(request *Request, route *Route) Framer {
+	exception := s.authorize(request)
+	if exception != &Success {
+		return request.Reply([]byte{}, exception)
+	}
+	return s.serve(request.withRoute(request.frame, route))
+}
+
+// serve forwards or rejects a request. It returns a nil exception if no
+// response is to be sent.
+func (route *Route) serve(frame Framer) ([]byte, *Exception) {
+	if route.Target == RouteReject {
+		return []byte{}, &GatewayPathUnavailable
+	}
+	return forward(route.Upstream, frame, route.Timeout)
+}
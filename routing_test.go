@@ -0,0 +1,114 @@
+package mbserver
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeTimeoutForwarder records the timeout of the forwarded requests.
+type fakeTimeoutForwarder struct {
+	fakeForwarder
+	timeout time.Duration
+}
+
+func (f *fakeTimeoutForwarder) ForwardTimeout(request Framer, timeout time.Duration) (Framer, error) {
+	f.timeout = timeout
+	return f.Forward(request)
+}
+
+func TestSetRoutes(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	err := s.SetRoutes([]Route{{FirstUnitID: 5, LastUnitID: 4}})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	err = s.SetRoutes([]Route{{FirstUnitID: 5, LastUnitID: 5, Target: RouteUpstream}})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	upstream := &fakeTimeoutForwarder{}
+	upstream.response = &TCPFrame{Device: 10, Function: 3, Data: []byte{2, 0, 42}}
+	gateway := &fakeForwarder{response: upstream.response}
+	s.SetGateway(gateway)
+	err := s.SetRoutes([]Route{
+		{FirstUnitID: 10, LastUnitID: 19, Target: RouteUpstream, Upstream: upstream, Timeout: time.Second},
+		{FirstUnitID: 15, LastUnitID: 25, Target: RouteReject},
+		{FirstUnitID: 30, LastUnitID: 30, Target: RouteLocal},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	tests := []struct {
+		unitID    byte
+		exception Exception
+		forwarded int
+	}{
+		{1, Success, 0},
+		{15, Success, 1},
+		{20, GatewayPathUnavailable, 1},
+		{40, Success, 2},
+	}
+	for _, test := range tests {
+		frame := TCPFrame{TransactionIdentifier: 9, Device: test.unitID, Function: 3}
+		SetDataWithRegisterAndNumber(&frame, 0, 1)
+		response := s.handle(&Request{frame: &frame})
+		if response == nil {
+			t.Fatalf("unit %d: expected response, got nil", test.unitID)
+		}
+		if exception := GetException(response); exception != test.exception {
+			t.Errorf("unit %d: expected %v, got %v", test.unitID, test.exception, exception)
+		}
+		if response.(*TCPFrame).TransactionIdentifier != 9 || response.GetAddress() != test.unitID {
+			t.Errorf("unit %d: expected the request header, got %v", test.unitID, response)
+		}
+		if forwarded := len(upstream.requests) + len(gateway.requests); forwarded != test.forwarded {
+			t.Errorf("unit %d: expected %v forwarded requests, got %v", test.unitID, test.forwarded, forwarded)
+		}
+	}
+	if upstream.timeout != time.Second {
+		t.Errorf("expected %v, got %v", time.Second, upstream.timeout)
+	}
+
+	// A local route is not forwarded to the gateway.
+	frame := TCPFrame{Device: 30, Function: 3}
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	if response := s.handle(&Request{frame: &frame}); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+
+	// Requests received on a serial line are not routed.
+	rtuFrame := RTUFrame{Address: 20, Function: 3, Data: frame.Data}
+	if response := s.handle(&Request{frame: &rtuFrame, diagnostics: newDiagnostics(), transport: TransportRTU}); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+}
+
+func TestSetRoutesConcurrent(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	routes := []Route{{FirstUnitID: 20, LastUnitID: 29, Target: RouteReject}}
+
+	// The routes are replaced while requests are handled.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.SetRoutes(routes)
+		}
+	}()
+	frame := TCPFrame{Device: 20, Function: 3}
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	for i := 0; i < 100; i++ {
+		s.handle(&Request{frame: &frame})
+	}
+	<-done
+
+	response := s.handle(&Request{frame: &frame})
+	if exception := GetException(response); exception != GatewayPathUnavailable {
+		t.Errorf("expected %v, got %v", GatewayPathUnavailable, exception)
+	}
+}
@@ -0,0 +1,113 @@
+package mbserver
+
+import (
+	"log"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// maxASCIIFrameLength is the maximum number of characters of a Modbus ASCII
+// frame.
+const maxASCIIFrameLength = 513
+
+// ListenASCII starts the Modbus server listening to a serial device using the
+// ASCII transmission mode.
+// For example:  err := s.ListenASCII("/dev/ttyUSB0", &serial.Mode{BaudRate: 9600, DataBits: 7, Parity: serial.EvenParity})
+func (s *Server) ListenASCII(name string, mode *serial.Mode) (err error) {
+	port, err := serial.Open(name, mode)
+	if err != nil {
+		log.Printf("failed to open %s: %v\n", name, err)
+		return err
+	}
+
+	// Frames are delimited by characters, the timeout only allows the port to
+	// be closed.
+	err = port.SetReadTimeout(100 * time.Millisecond)
+	if err != nil {
+		port.Close()
+		log.Printf("failed to set read timeout of %s: %v\n", name, err)
+		return err
+	}
+
+	s.portsMutex.Lock()
+	s.ports = append(s.ports, port)
+	s.portsMutex.Unlock()
+
+	s.portsWG.Add(1)
+	go func() {
+		defer s.portsWG.Done()
+		err := s.acceptASCIIRequests(port)
+		if err != nil {
+			log.Printf("stopped serving %s: %v\n", name, err)
+		}
+	}()
+
+	return nil
+}
+
+// acceptASCIIRequests reads frames from a serial port until the server is
+// closed or reading the port fails.
+func (s *Server) acceptASCIIRequests(port serial.Port) error {
+	buffer := make([]byte, 256)
+	diag := newDiagnostics()
+	connID := s.newConnID()
+	// packet is nil until the colon starting a frame is received.
+	var packet []byte
+
+	for {
+		select {
+		case <-s.portsCloseChan:
+			return nil
+		default:
+		}
+
+		bytesRead, err := port.Read(buffer)
+		if err != nil {
+			return err
+		}
+
+		for _, b := range buffer[:bytesRead] {
+			if b == ':' {
+				// A colon always starts a new frame.
+				packet = []byte{b}
+				continue
+			}
+			if packet == nil {
+				continue
+			}
+
+			packet = append(packet, b)
+			pLen := len(packet)
+			if b == diag.delimiter() && packet[pLen-2] == '\r' {
+				s.acceptASCIIPacket(port, packet, diag, connID)
+				packet = nil
+			} else if pLen >= maxASCIIFrameLength {
+				diag.increment(busMessageCount)
+				diag.increment(busCharacterOverrunCount)
+				diag.addEvent(eventReceive | eventReceiveCharacterOverrun)
+				packet = nil
+			}
+		}
+	}
+}
+
+func (s *Server) acceptASCIIPacket(port serial.Port, packet []byte, diag *diagnostics, connID uint64) {
+	diag.increment(busMessageCount)
+	frame, err := NewASCIIFrame(packet)
+	if err != nil {
+		diag.increment(busCommunicationErrorCount)
+		diag.addEvent(eventReceive | eventReceiveCommunicationError)
+		return
+	}
+
+	request := &Request{
+		conn:        port,
+		frame:       frame,
+		diagnostics: diag,
+		transport:   TransportASCII,
+		connID:      connID,
+		received:    time.Now(),
+	}
+	s.requestChan <- request
+}
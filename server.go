@@ -2,36 +2,60 @@
 package mbserver
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"go.bug.st/serial"
 	"io"
 	"net"
 	"sync"
+	"time"
+)
+
+// UnitZeroMode selects how requests for unit ID 0 received over TCP are handled
+// by servers not hosting unit ID 0.
+type UnitZeroMode int
+
+const (
+	// UnitZeroIgnore drops the request without a response.
+	UnitZeroIgnore UnitZeroMode = iota
+	// UnitZeroBroadcast handles the request as a broadcast, as on a serial line.
+	UnitZeroBroadcast
+	// UnitZeroLowerSlave addresses the request to the lowest slave unit ID.
+	UnitZeroLowerSlave
 )
 
 // Server is a Modbus slave with allocated memory for discrete inputs, coils, etc.
 type Server struct {
 	// Debug enables more verbose messaging.
 	Debug                bool
+	TCPUnitZero          UnitZeroMode  // handling of TCP requests for unit ID 0
+	RequestTimeout       time.Duration // deadline of the handler context, none if 0
 	listeners            []net.Listener
+	packetConns          []net.PacketConn
 	ports                []serial.Port
+	portsMutex           sync.Mutex
 	portsWG              sync.WaitGroup
 	portsCloseChan       chan struct{}
 	requestChan          chan *Request
-	function             [256](func(*Server, Framer) ([]byte, *Exception))
+	handlers             [256]Handler
+	middleware           []Middleware
+	chain                Responder // dispatch wrapped in the middleware
+	mei                  [256]Handler
+	broadcast            [256]bool
+	authorization        AuthorizationPolicy
+	gateway              Forwarder
+	serialRoutes         [256]*SerialRoute
+	routes               [256]*Route
+	routesMutex          sync.Mutex
 	slaves               []SlaveMemory
 	lowerSlaveId         byte
 	upperSlaveId         byte
 	offsetInputRegisters uint16 // offset to copy from HR to IR
 	offsetDiscreteInputs uint16 // offset to copy from Coils to DI
-	ListenState
-}
-
-type ListenState struct {
-	state     int
-	bytesLeft int
-	buffer    []byte
-	packet    []byte
-	hasErr    bool
+	connIDs              uint64
+	ctx                  context.Context
+	cancel               context.CancelFunc
 }
 
 type SlaveMemory struct {
@@ -39,12 +63,32 @@ type SlaveMemory struct {
 	Coils            []byte
 	HoldingRegisters []uint16
 	InputRegisters   []uint16
+	fifoQueues       map[uint16][]uint16
+	fifoMutex        sync.Mutex
+	files            map[uint16][]uint16
+	fileMutex        sync.Mutex
+	deviceID         map[byte]string
+	deviceIDMutex    sync.Mutex
+	serverID         []byte
+	running          bool
+	serverIDData     []byte
+	exceptionStatus  byte
+	exceptionCoils   *[8]uint16
+	statusMutex      sync.Mutex
 }
 
 // Request contains the connection and Modbus frame.
 type Request struct {
-	conn  io.ReadWriteCloser
-	frame Framer
+	conn        io.ReadWriteCloser
+	frame       Framer
+	diagnostics *diagnostics // nil unless received on a serial port
+	role        string       // Modbus/TCP Security role of the client
+	transport   Transport
+	remoteAddr  net.Addr
+	connID      uint64
+	tlsState    *tls.ConnectionState
+	received    time.Time
+	route       *Route // set if the request is forwarded or rejected
 }
 
 // NewServer creates a new Modbus server (slave).
@@ -65,28 +109,45 @@ func NewServer(LowerID, UpperID byte, OffsetInputRegisters uint16, OffsetDiscret
 		slaves[i].Coils = make([]byte, 65536)
 		slaves[i].HoldingRegisters = make([]uint16, 65536)
 		slaves[i].InputRegisters = make([]uint16, 65536)
+		slaves[i].fifoQueues = make(map[uint16][]uint16)
+		slaves[i].running = true
 	}
 
 	s.slaves = slaves
 
 	// Add default functions.
-	s.function[1] = ReadCoils
-	s.function[2] = ReadDiscreteInputs
-	s.function[3] = ReadHoldingRegisters
-	s.function[4] = ReadInputRegisters
-	s.function[5] = WriteSingleCoil
-	s.function[6] = WriteHoldingRegister
-	s.function[15] = WriteMultipleCoils
-	s.function[16] = WriteHoldingRegisters
-
-	ls := ListenState{}
-	ls.buffer = make([]byte, 256)
-	ls.hasErr = false
-	ls.bytesLeft = 0
-	s.ListenState = ls
+	s.RegisterFunctionHandler(1, ReadCoils)
+	s.RegisterFunctionHandler(2, ReadDiscreteInputs)
+	s.RegisterFunctionHandler(3, ReadHoldingRegisters)
+	s.RegisterFunctionHandler(4, ReadInputRegisters)
+	s.RegisterFunctionHandler(5, WriteSingleCoil)
+	s.RegisterFunctionHandler(6, WriteHoldingRegister)
+	s.RegisterFunctionHandler(7, ReadExceptionStatus)
+	s.RegisterHandler(8, HandlerFunc(Diagnostics))
+	s.RegisterHandler(11, HandlerFunc(GetCommEventCounter))
+	s.RegisterHandler(12, HandlerFunc(GetCommEventLog))
+	s.RegisterFunctionHandler(15, WriteMultipleCoils)
+	s.RegisterFunctionHandler(16, WriteHoldingRegisters)
+	s.RegisterFunctionHandler(17, ReportServerID)
+	s.RegisterFunctionHandler(20, ReadFileRecord)
+	s.RegisterFunctionHandler(21, WriteFileRecord)
+	s.RegisterFunctionHandler(22, MaskWriteRegister)
+	s.RegisterFunctionHandler(23, ReadWriteMultipleRegisters)
+	s.RegisterFunctionHandler(24, ReadFIFOQueue)
+	s.RegisterHandler(43, HandlerFunc(s.EncapsulatedInterfaceTransport))
+
+	// Add default broadcast functions.
+	for _, function := range []uint8{5, 6, 15, 16, 21, 22} {
+		s.broadcast[function] = true
+	}
+
+	// Add default MEI types.
+	s.mei[14] = s.FunctionHandler(ReadDeviceIdentification)
 
 	s.requestChan = make(chan *Request)
 	s.portsCloseChan = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.chain = ResponderFunc(s.dispatch)
 
 	go s.handler()
 
@@ -95,54 +156,225 @@ func NewServer(LowerID, UpperID byte, OffsetInputRegisters uint16, OffsetDiscret
 
 // RegisterFunctionHandler override the default behavior for a given Modbus function.
 func (s *Server) RegisterFunctionHandler(funcCode uint8, function func(*Server, Framer) ([]byte, *Exception)) {
-	s.function[funcCode] = function
+	if function == nil {
+		s.handlers[funcCode] = nil
+		return
+	}
+	s.handlers[funcCode] = s.FunctionHandler(function)
+}
+
+// RegisterMEIHandler sets the handler of an MEI type of function 43, a nil
+// handler removes the MEI type. The handler receives the whole request, the
+// data of its frame starting with the MEI type.
+func (s *Server) RegisterMEIHandler(meiType uint8, handler Handler) {
+	s.mei[meiType] = handler
+}
+
+// SetBroadcastFunction sets whether a function is executed on every slave when
+// a request for it is broadcast to unit ID 0. By default the write functions
+// 5, 6, 15, 16, 21 and 22 are broadcast; requests for other functions are
+// ignored.
+func (s *Server) SetBroadcastFunction(funcCode uint8, broadcast bool) {
+	s.broadcast[funcCode] = broadcast
+}
+
+// Slave returns the memory of the slave with the given unit ID.
+func (s *Server) Slave(slaveID byte) (*SlaveMemory, error) {
+	if slaveID < s.lowerSlaveId || slaveID > s.upperSlaveId {
+		return nil, fmt.Errorf("slave id %d out of range %d..%d", slaveID, s.lowerSlaveId, s.upperSlaveId)
+	}
+	return &s.slaves[s.upperSlaveId-slaveID], nil
 }
 
 func (s *Server) handle(request *Request) Framer {
-	var exception *Exception
-	var data []byte
+	frame := request.frame
+	slaveId := frame.GetAddress()
+	response := frame.Copy()
+	function := frame.GetFunction()
 
-	slaveId := request.frame.GetAddress()
-	response := request.frame.Copy()
-	function := request.frame.GetFunction()
+	route := s.route(request)
+	if route != nil && route.Target != RouteLocal {
+		return s.handleRoute(request, route)
+	}
+
+	// Requests are forwarded by serial routes and, for unit IDs not hosted
+	// nor routed, by the gateway.
+	diag := request.diagnostics
+	var upstream *Route
+	if serialRoute := s.serialRoute(request); serialRoute != nil {
+		upstream = &Route{Target: RouteUpstream, Upstream: serialRoute.Forwarder}
+		frame = &unitFrame{Framer: frame, address: serialRoute.UnitID}
+	} else if slaveId == 0 {
+		_, isTCP := frame.(*TCPFrame)
+		if !isTCP || s.TCPUnitZero == UnitZeroBroadcast {
+			s.handleBroadcast(request)
+			return nil
+		}
+		// Servers hosting unit ID 0 serve it as any other slave.
+		if s.lowerSlaveId != 0 {
+			if s.TCPUnitZero != UnitZeroLowerSlave {
+				return nil
+			}
+			frame = &unitFrame{Framer: frame, address: s.lowerSlaveId}
+		}
+	} else if slaveId < s.lowerSlaveId || slaveId > s.upperSlaveId {
+		if !s.gatewayRequest(request, route) {
+			return nil
+		}
+		upstream = &Route{Target: RouteUpstream, Upstream: s.gateway}
+	}
 
-	if slaveId < s.lowerSlaveId || slaveId > s.upperSlaveId {
-		return nil
-	} else if s.function[function] != nil {
-		data, exception = s.function[function](s, request.frame)
-		response.SetData(data)
+	// In listen only mode only a restart of communications is processed.
+	listenOnly := false
+	if diag != nil {
+		diag.increment(serverMessageCount)
+		listenOnly = diag.isListenOnly()
+		diag.receiveEvent(frame, listenOnly)
+		if listenOnly && !isRestartCommunications(frame) {
+			diag.increment(serverNoResponseCount)
+			return nil
+		}
+	}
+
+	authorized := s.authorize(request)
+	if authorized != &Success {
+		response.SetException(authorized)
+	} else if len(frame.GetData()) < minRequestDataLength(function) {
+		response.SetException(&IllegalDataValue)
 	} else {
-		exception = &IllegalFunction
+		if upstream != nil {
+			response = s.serve(request.withRoute(frame, upstream))
+		} else {
+			response = s.serve(request.WithFrame(frame))
+		}
+		if response == nil {
+			// No response is expected.
+			if diag != nil {
+				diag.increment(serverNoResponseCount)
+			}
+			return nil
+		}
 	}
 
-	if exception != &Success {
-		response.SetException(exception)
+	if diag != nil {
+		// No response is sent when entering or leaving listen only mode.
+		if listenOnly || diag.isListenOnly() {
+			diag.increment(serverNoResponseCount)
+			return nil
+		}
+		exception := GetException(response)
+		if exception != Success {
+			diag.countException(exception)
+		} else if function != 11 && function != 12 {
+			diag.incrementEventCounter()
+		}
+		diag.sendEvent(exception)
 	}
 
 	return response
 }
 
+// handleBroadcast executes a request for unit ID 0 on every slave. Only
+// broadcast functions are executed and no response is ever returned.
+func (s *Server) handleBroadcast(request *Request) {
+	function := request.frame.GetFunction()
+
+	diag := request.diagnostics
+	if diag != nil {
+		diag.increment(serverMessageCount)
+		diag.increment(serverNoResponseCount)
+		listenOnly := diag.isListenOnly()
+		diag.receiveEvent(request.frame, listenOnly)
+		if listenOnly {
+			return
+		}
+	}
+
+	if !s.broadcast[function] || s.handlers[function] == nil || s.authorize(request) != &Success {
+		return
+	}
+	if len(request.frame.GetData()) < minRequestDataLength(function) {
+		return
+	}
+	for slaveID := int(s.lowerSlaveId); slaveID <= int(s.upperSlaveId); slaveID++ {
+		s.serve(request.WithFrame(&unitFrame{Framer: request.frame, address: byte(slaveID)}))
+	}
+	if diag != nil {
+		diag.incrementEventCounter()
+	}
+}
+
+// serve passes a request to the middleware and the handler of its function,
+// or its route, and returns the response frame.
+func (s *Server) serve(request *Request) Framer {
+	ctx, cancel := s.requestContext()
+	defer cancel()
+	return s.chain.Respond(ctx, request)
+}
+
+// requestContext returns the context of a handler, done when the server is
+// closed or the request timeout expires.
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+	if s.RequestTimeout > 0 {
+		return context.WithTimeout(s.ctx, s.RequestTimeout)
+	}
+	return context.WithCancel(s.ctx)
+}
+
 // All requests are handled synchronously to prevent modbus memory corruption.
+// Forwarded requests only wait for remote devices, they are handled by their
+// own goroutines.
 func (s *Server) handler() {
 	for {
 		request := <-s.requestChan
-		response := s.handle(request)
-		if response != nil {
-			request.conn.Write(response.Bytes())
+		if s.forwarded(request) {
+			go s.respond(request)
+			continue
 		}
+		s.respond(request)
+	}
+}
+
+// respond handles a request and writes its response, if any.
+func (s *Server) respond(request *Request) {
+	response := s.handle(request)
+	if response != nil {
+		request.conn.Write(response.Bytes())
 	}
 }
 
-// Close stops listening to TCP/IP ports and closes serial ports.
+// forwarded returns true if a request is forwarded to a remote device. Modbus
+// TCP responses are matched by transaction ID, so requests of upstream routes
+// need not hold up the following requests, nor need requests forwarded by the
+// gateway or by serial routes.
+func (s *Server) forwarded(request *Request) bool {
+	route := s.route(request)
+	if _, isTCP := request.frame.(*TCPFrame); isTCP && route != nil && route.Target == RouteUpstream {
+		return true
+	}
+	return s.gatewayRequest(request, route) || s.serialRoute(request) != nil
+}
+
+// Close stops listening to TCP/IP and UDP ports and closes serial ports.
 func (s *Server) Close() {
+	s.cancel()
+
 	for _, listen := range s.listeners {
 		listen.Close()
 	}
 
+	for _, conn := range s.packetConns {
+		conn.Close()
+	}
+
+	s.portsMutex.Lock()
 	close(s.portsCloseChan)
+	s.portsMutex.Unlock()
 	s.portsWG.Wait()
 
+	s.portsMutex.Lock()
 	for _, port := range s.ports {
 		port.Close()
 	}
+	s.portsMutex.Unlock()
 }
@@ -1,6 +1,8 @@
 package mbserver
 
 import (
+	"io"
+	"net"
 	"testing"
 	"time"
 
@@ -45,6 +47,108 @@ func TestUnsupportedFunction(t *testing.T) {
 	}
 }
 
+func TestShortRequest(t *testing.T) {
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	tests := []struct {
+		packet []byte
+		expect Exception
+	}{
+		{[]byte{1, 3}, IllegalDataValue},
+		{[]byte{1, 6, 0, 1, 0}, IllegalDataValue},
+		{[]byte{1, 16, 0, 1, 0, 1}, IllegalDataValue},
+		{[]byte{1, 22, 0, 1, 0, 0, 0}, IllegalDataValue},
+		{[]byte{1, 23, 0, 0, 0, 1, 0, 0, 0, 1}, IllegalDataValue},
+		{[]byte{1, 11}, Success},
+		{[]byte{1, 12}, Success},
+		{[]byte{1, 7}, Success},
+		{[]byte{1, 17}, Success},
+	}
+	for _, test := range tests {
+		request := &RTUFrame{Address: test.packet[0], Function: test.packet[1], Data: test.packet[2:]}
+		frame, err := NewRTUFrame(request.Bytes())
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		response := s.handle(&Request{frame: frame, diagnostics: newDiagnostics()})
+		exception := GetException(response)
+		if exception != test.expect {
+			t.Errorf("%v: expected %v, got %v", test.packet, test.expect.String(), exception.String())
+		}
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	var LowerID, UpperID byte = 1, 2
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	var frame RTUFrame
+	frame.Address = 0
+	frame.Function = 6
+	SetDataWithRegisterAndNumber(&frame, 5, 6)
+	var req Request
+	req.frame = &frame
+	req.diagnostics = newDiagnostics()
+
+	// Write functions are executed on every slave without a response.
+	response := s.handle(&req)
+	if response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+	expect := []uint16{6, 6}
+	got := []uint16{s.slaves[0].HoldingRegisters[5], s.slaves[1].HoldingRegisters[5]}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if count := req.diagnostics.counter(serverNoResponseCount); count != 1 {
+		t.Errorf("expected 1 no response, got %v", count)
+	}
+
+	// Read functions are ignored.
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 5, 1)
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+
+	// Unit ID 0 is ignored on TCP by default.
+	var tcpFrame TCPFrame
+	tcpFrame.Device = 0
+	tcpFrame.Function = 6
+	SetDataWithRegisterAndNumber(&tcpFrame, 7, 8)
+	req = Request{frame: &tcpFrame}
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+	if s.slaves[0].HoldingRegisters[7] != 0 {
+		t.Errorf("expected TCP unit ID 0 to be ignored")
+	}
+
+	s.TCPUnitZero = UnitZeroLowerSlave
+	response = s.handle(&req)
+	if response == nil || response.GetAddress() != 0 {
+		t.Fatalf("expected response for unit ID 0, got %v", response)
+	}
+	expect = []uint16{0, 8}
+	got = []uint16{s.slaves[0].HoldingRegisters[7], s.slaves[1].HoldingRegisters[7]}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	s.TCPUnitZero = UnitZeroBroadcast
+	tcpFrame.Function = 6
+	SetDataWithRegisterAndNumber(&tcpFrame, 7, 9)
+	if response = s.handle(&req); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+	expect = []uint16{9, 9}
+	got = []uint16{s.slaves[0].HoldingRegisters[7], s.slaves[1].HoldingRegisters[7]}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
 func TestModbus(t *testing.T) {
 	// Server
 	var LowerID, UpperID byte = 1, 1
@@ -142,3 +246,309 @@ func TestModbus(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestModbusTCPStream(t *testing.T) {
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	err := s.ListenTCP("127.0.0.1:3337")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:3337")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	write := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 6}
+	SetDataWithRegisterAndNumber(write, 1, 3)
+	read := &TCPFrame{TransactionIdentifier: 2, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(read, 1, 1)
+
+	// Two pipelined transactions in one segment, then a transaction split
+	// across segments.
+	packet := append(write.Bytes(), read.Bytes()...)
+	read.TransactionIdentifier = 3
+	conn.Write(append(packet, read.Bytes()[:5]...))
+	time.Sleep(10 * time.Millisecond)
+	conn.Write(read.Bytes()[5:])
+
+	expect := append(write.Bytes(), []byte{0, 2, 0, 0, 0, 5, 1, 3, 2, 0, 3, 0, 3, 0, 0, 0, 5, 1, 3, 2, 0, 3}...)
+	got := make([]byte, len(expect))
+	for n := 0; n < len(got); {
+		bytesRead, err := conn.Read(got[n:])
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		n += bytesRead
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// An oversize MBAP length closes the connection.
+	conn.Write([]byte{0, 4, 0, 0, 0x01, 0x00, 1, 3})
+	_, err = conn.Read(got)
+	if err == nil {
+		t.Errorf("expected connection to be closed")
+	}
+}
+
+func TestModbusUnitZero(t *testing.T) {
+	// Server hosting unit ID 0.
+	var LowerID, UpperID byte = 0, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	err := s.ListenTCP("127.0.0.1:3348")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	// Client
+	handler := modbus.NewTCPClientHandler("127.0.0.1:3348")
+	handler.SlaveId = 0
+	handler.Timeout = time.Second
+	err = handler.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+
+	client := modbus.NewClient(handler)
+	_, err = client.WriteSingleRegister(5, 0x1234)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	results, err := client.ReadHoldingRegisters(5, 1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	expect := []byte{0x12, 0x34}
+	if !isEqual(expect, results) {
+		t.Errorf("expected %v, got %v", expect, results)
+	}
+
+	// Only slave 0 is written.
+	slave, _ := s.Slave(1)
+	if slave.HoldingRegisters[5] != 0 {
+		t.Errorf("expected 0, got %v", slave.HoldingRegisters[5])
+	}
+}
+
+func TestModbusTCPShortFrame(t *testing.T) {
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	err := s.ListenTCP("127.0.0.1:3345")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:3345")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Requests too short for their function, such as 00 01 00 00 00 03 01 03
+	// 00, are answered with an exception.
+	for _, function := range []uint8{1, 2, 3, 4, 5, 6, 15, 16} {
+		for _, data := range [][]byte{{}, {0}, {0, 1, 0, 1}} {
+			if len(data) == 4 && function != 15 && function != 16 {
+				continue
+			}
+			request := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: function}
+			request.SetData(data)
+			conn.Write(request.Bytes())
+
+			expect := []byte{0, 1, 0, 0, 0, 3, 1, function | 0x80, byte(IllegalDataValue)}
+			got := make([]byte, len(expect))
+			_, err := io.ReadFull(conn, got)
+			if err != nil {
+				t.Fatalf("function %v %v: expected nil, got %v\n", function, data, err)
+			}
+			if !isEqual(expect, got) {
+				t.Errorf("function %v %v: expected %v, got %v", function, data, expect, got)
+			}
+		}
+	}
+}
+
+func TestModbusTCPNoData(t *testing.T) {
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+	s.SetExceptionStatus(1, 0x6d)
+
+	err := s.ListenTCP("127.0.0.1:3347")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:3347")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Requests of functions 7 and 17 have no data.
+	tests := []struct {
+		function uint8
+		expect   []byte
+	}{
+		{7, []byte{0x6d}},
+		{17, []byte{2, 1, 0xff}},
+	}
+	for _, test := range tests {
+		request := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function}
+		request.SetData([]byte{})
+		conn.Write(request.Bytes())
+
+		response := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function}
+		response.SetData(test.expect)
+		expect := response.Bytes()
+		got := make([]byte, len(expect))
+		_, err := io.ReadFull(conn, got)
+		if err != nil {
+			t.Fatalf("function %v: expected nil, got %v\n", test.function, err)
+		}
+		if !isEqual(expect, got) {
+			t.Errorf("function %v: expected %v, got %v", test.function, expect, got)
+		}
+	}
+}
+
+func TestModbusRTUOverTCP(t *testing.T) {
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	err := s.ListenRTUOverTCP("127.0.0.1:3334")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:3334")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	write := &RTUFrame{Address: 1, Function: 6}
+	SetDataWithRegisterAndNumber(write, 1, 3)
+	read := &RTUFrame{Address: 1, Function: 3}
+	SetDataWithRegisterAndNumber(read, 1, 1)
+
+	// Two frames in one segment, then a frame split across segments.
+	packet := append(write.Bytes(), read.Bytes()...)
+	conn.Write(append(packet, read.Bytes()[:3]...))
+	time.Sleep(10 * time.Millisecond)
+	conn.Write(read.Bytes()[3:])
+
+	expect := append(write.Bytes(), []byte{1, 3, 2, 0, 3, 0xf8, 0x45, 1, 3, 2, 0, 3, 0xf8, 0x45}...)
+	got := make([]byte, len(expect))
+	for n := 0; n < len(got); {
+		bytesRead, err := conn.Read(got[n:])
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		n += bytesRead
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// Return Query Data requests have data of any length. Diagnostics are
+	// not available without a serial port, the connection is kept.
+	query := &RTUFrame{Address: 1, Function: 8, Data: []byte{0, 0, 1, 2, 3, 4}}
+	conn.Write(query.Bytes())
+	response := &RTUFrame{Address: 1, Function: 0x88, Data: []byte{byte(IllegalFunction)}}
+	expect = response.Bytes()
+	got = make([]byte, len(expect))
+	_, err = io.ReadFull(conn, got)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	conn.Write(read.Bytes())
+	expect = []byte{1, 3, 2, 0, 3, 0xf8, 0x45}
+	got = make([]byte, len(expect))
+	_, err = io.ReadFull(conn, got)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestModbusUDP(t *testing.T) {
+	// Server
+	var LowerID, UpperID byte = 1, 1
+	s := NewServer(LowerID, UpperID, 30000, 30000)
+
+	err := s.ListenUDP("127.0.0.1:3335")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp", "127.0.0.1:3335")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// A datagram with a malformed MBAP length is dropped.
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	packet := frame.Bytes()
+	packet[5]++
+	conn.Write(packet)
+
+	frame.TransactionIdentifier = 2
+	conn.Write(frame.Bytes())
+
+	response := make([]byte, 512)
+	bytesRead, err := conn.Read(response)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	expect := []byte{0, 2, 0, 0, 0, 5, 1, 3, 2, 0, 0}
+	got := response[:bytesRead]
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,112 @@
+package mbserver
+
+import "fmt"
+
+// SetServerID sets the data returned by Report Server ID (function 17) for the
+// slave: the device specific server ID, the run indicator status and any
+// additional data. By default a slave reports its unit ID and is running.
+func (m *SlaveMemory) SetServerID(serverID []byte, running bool, additionalData []byte) error {
+	// Byte count, server ID, run indicator and additional data must fit a PDU.
+	if len(serverID)+1+len(additionalData) > 251 {
+		return fmt.Errorf("server id: %d bytes exceeds 251", len(serverID)+1+len(additionalData))
+	}
+
+	m.statusMutex.Lock()
+	defer m.statusMutex.Unlock()
+
+	m.serverID = append([]byte(nil), serverID...)
+	m.running = running
+	m.serverIDData = append([]byte(nil), additionalData...)
+	return nil
+}
+
+// SetExceptionStatus sets the exception status byte returned by Read Exception
+// Status (function 7) for the slave. Any coils bound with BindExceptionStatus
+// are unbound.
+func (m *SlaveMemory) SetExceptionStatus(status byte) {
+	m.statusMutex.Lock()
+	defer m.statusMutex.Unlock()
+
+	m.exceptionStatus = status
+	m.exceptionCoils = nil
+}
+
+// BindExceptionStatus binds the exception status byte returned by Read
+// Exception Status (function 7) for the slave to eight coils, coils[0] being
+// the least significant bit.
+func (m *SlaveMemory) BindExceptionStatus(coils [8]uint16) {
+	m.statusMutex.Lock()
+	defer m.statusMutex.Unlock()
+
+	m.exceptionCoils = &coils
+}
+
+// SetServerID sets the data returned by Report Server ID (function 17) for a
+// slave of the server.
+func (s *Server) SetServerID(slaveID byte, serverID []byte, running bool, additionalData []byte) error {
+	slave, err := s.Slave(slaveID)
+	if err != nil {
+		return err
+	}
+	return slave.SetServerID(serverID, running, additionalData)
+}
+
+// SetExceptionStatus sets the exception status byte returned by Read Exception
+// Status (function 7) for a slave of the server.
+func (s *Server) SetExceptionStatus(slaveID byte, status byte) error {
+	slave, err := s.Slave(slaveID)
+	if err != nil {
+		return err
+	}
+	slave.SetExceptionStatus(status)
+	return nil
+}
+
+// BindExceptionStatus binds the exception status byte returned by Read
+// Exception Status (function 7) for a slave of the server to eight coils.
+func (s *Server) BindExceptionStatus(slaveID byte, coils [8]uint16) error {
+	slave, err := s.Slave(slaveID)
+	if err != nil {
+		return err
+	}
+	slave.BindExceptionStatus(coils)
+	return nil
+}
+
+// reportServerID returns the byte count, server ID, run indicator status and
+// additional data of a slave.
+func (m *SlaveMemory) reportServerID(slaveID byte) []byte {
+	m.statusMutex.Lock()
+	defer m.statusMutex.Unlock()
+
+	serverID := m.serverID
+	if serverID == nil {
+		serverID = []byte{slaveID}
+	}
+	data := []byte{byte(len(serverID) + 1 + len(m.serverIDData))}
+	data = append(data, serverID...)
+	if m.running {
+		data = append(data, 0xff)
+	} else {
+		data = append(data, 0x00)
+	}
+	return append(data, m.serverIDData...)
+}
+
+// exceptionStatusByte returns the exception status of a slave, read from the
+// bound coils if any.
+func (m *SlaveMemory) exceptionStatusByte() byte {
+	m.statusMutex.Lock()
+	defer m.statusMutex.Unlock()
+
+	if m.exceptionCoils == nil {
+		return m.exceptionStatus
+	}
+	var status byte
+	for i, coil := range m.exceptionCoils {
+		if m.Coils[coil] != 0 {
+			status |= 1 << uint(i)
+		}
+	}
+	return status
+}
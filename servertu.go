@@ -1,109 +1,337 @@
 package mbserver
 
 import (
+	"io"
 	"log"
+	"net"
 	"time"
 
 	"go.bug.st/serial"
 )
 
-// ListenRTU starts the Modbus server listening to a serial device.
-// For example:  err := s.ListenRTU(&serial.Config{Address: "/dev/ttyUSB0"})
+// idleReadTimeout is the read timeout of serial ports, it only bounds the time
+// taken to notice the port is no longer served.
+const idleReadTimeout = 100 * time.Millisecond
+
+// RTUOptions configures the framing of ServeRTU.
+type RTUOptions struct {
+	// Mode of the serial line, it gives the inter-frame silent interval
+	// (t3.5). If nil the interval is that of 19200 baud 8N1.
+	Mode *serial.Mode
+	// FrameSilence overrides the interval given by Mode, e.g. for streams
+	// with network latency such as RFC 2217.
+	FrameSilence time.Duration
+	// CharSilence is the longest silent interval between the characters of
+	// a frame (t1.5), a frame with a longer gap is discarded. Gaps are not
+	// checked unless it is set, as USB serial adapters and the scheduler
+	// delay characters by far more than t1.5 (750us above 19200 baud).
+	CharSilence time.Duration
+}
+
+// Silence returns the inter-frame silent interval (t3.5) of the options.
+func (opts RTUOptions) Silence() time.Duration {
+	if opts.FrameSilence > 0 {
+		return opts.FrameSilence
+	}
+	mode := opts.Mode
+	if mode == nil {
+		mode = &serial.Mode{BaudRate: 19200}
+	}
+	_, frameSilence := rtuTiming(mode)
+	return frameSilence
+}
+
+// charSilence returns the inter-character silent interval (t1.5) of the
+// options, 0 if gaps between characters are not checked.
+func (opts RTUOptions) charSilence() time.Duration {
+	if opts.CharSilence < 0 {
+		return 0
+	}
+	return opts.CharSilence
+}
+
+// rtuPort holds the receive state of a stream serving RTU frames, so any
+// number of streams can be served by one Server.
+type rtuPort struct {
+	conn         io.ReadWriteCloser
+	remoteAddr   net.Addr // set if the stream is a network connection
+	connID       uint64
+	frameSilence time.Duration
+	charSilence  time.Duration
+	diagnostics  *diagnostics
+	packet       []byte
+	lastChunk    time.Time // time the last bytes were received
+	hasErr       bool      // discard the received bytes until the end of frame
+}
+
+// ListenRTU starts the Modbus server listening to a serial device. It may be
+// called for several serial devices.
+// For example:  err := s.ListenRTU("/dev/ttyUSB0", &serial.Mode{BaudRate: 19200})
 func (s *Server) ListenRTU(name string, mode *serial.Mode) (err error) {
-	port, err := serial.Open(name, mode)
+	port, err := openRTUPort(name, mode)
 	if err != nil {
-		log.Fatalf("failed to open %s: %v\n", name, err)
+		log.Printf("failed to open %s: %v\n", name, err)
+		return err
 	}
 
-	err = port.SetMode(mode)
+	s.portsWG.Add(1)
+	go func() {
+		defer s.portsWG.Done()
+		err := s.serveRTU(port, RTUOptions{Mode: mode})
+		if err != nil {
+			log.Printf("stopped serving %s: %v\n", name, err)
+		}
+	}()
+
+	return nil
+}
+
+// openRTUPort opens a serial device to be served by serveRTU.
+func openRTUPort(name string, mode *serial.Mode) (serial.Port, error) {
+	port, err := serial.Open(name, mode)
 	if err != nil {
-		log.Print(err)
+		return nil, err
 	}
 
-	err = port.SetReadTimeout(30 * time.Millisecond)
+	err = port.SetReadTimeout(idleReadTimeout)
 	if err != nil {
-		log.Print(err)
+		port.Close()
+		return nil, err
 	}
+	return port, nil
+}
 
-	buffer = make([]byte, 256)
+// ServeRTU serves RTU frames received on a stream, such as a pty, an RFC 2217
+// connection or a net.Pipe, and writes the responses to it. It blocks until
+// the server is closed, returning nil, or reading the stream fails. The stream
+// is closed when ServeRTU returns.
+func (s *Server) ServeRTU(conn io.ReadWriteCloser, opts RTUOptions) error {
+	// ServeRTU may be called concurrently with Close.
+	s.portsMutex.Lock()
+	select {
+	case <-s.portsCloseChan:
+		s.portsMutex.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	s.portsWG.Add(1)
+	s.portsMutex.Unlock()
 
-	s.ports = append(s.ports, port)
+	defer s.portsWG.Done()
+	return s.serveRTU(conn, opts)
+}
 
-	s.portsWG.Add(1)
-	go func() {
-		defer s.portsWG.Done()
-		s.acceptSerialRequests(port)
-	}()
+// rtuTiming returns the inter-character (t1.5) and inter-frame (t3.5) silent
+// intervals of a serial mode. Above 19200 baud the fixed values recommended by
+// the Modbus serial line specification are used.
+func rtuTiming(mode *serial.Mode) (charSilence time.Duration, frameSilence time.Duration) {
+	if mode.BaudRate > 19200 {
+		return 750 * time.Microsecond, 1750 * time.Microsecond
+	}
+	baudRate := mode.BaudRate
+	if baudRate <= 0 {
+		baudRate = 9600
+	}
 
-	return err
+	// Bits per character, in halves to account for 1.5 stop bits.
+	dataBits := mode.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	halfBits := 2 * (1 + dataBits)
+	if mode.Parity != serial.NoParity {
+		halfBits += 2
+	}
+	switch mode.StopBits {
+	case serial.OnePointFiveStopBits:
+		halfBits += 3
+	case serial.TwoStopBits:
+		halfBits += 4
+	default:
+		halfBits += 2
+	}
+
+	// 1.5 and 3.5 characters of halfBits/2 bits each.
+	charSilence = 3 * time.Duration(halfBits) * time.Second / time.Duration(4*baudRate)
+	frameSilence = 7 * time.Duration(halfBits) * time.Second / time.Duration(4*baudRate)
+	return charSilence, frameSilence
 }
 
-// TODO: think about use multiple serial ports in one application
-var buffer []byte
+// serveRTU reads RTU frames from a stream. Frames end after frameSilence
+// without data or, when the function code defines it, once the expected
+// number of bytes is received, so back-to-back frames are split even if the
+// stream delivers them in one read.
+func (s *Server) serveRTU(conn io.ReadWriteCloser, opts RTUOptions) error {
+	defer conn.Close()
 
-func (s *Server) acceptSerialRequests(port serial.Port) {
-	const (
-		InitialState = iota // receive all incoming data from serial port on init or err
-		ReceiveState        // try to read byte stream from port before timeout
-		ControlState        // return of reconstructed frame to check data and prepare response
-	)
+	p := &rtuPort{
+		conn:         conn,
+		connID:       s.newConnID(),
+		frameSilence: opts.Silence(),
+		charSilence:  opts.charSilence(),
+		diagnostics:  newDiagnostics(),
+	}
+	if addr, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		p.remoteAddr = addr.RemoteAddr()
+	}
+
+	// The stream is read by its own goroutine so the end of frame silence can
+	// be timed whether or not the stream supports read timeouts.
+	done := make(chan struct{})
+	defer close(done)
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	go readRTUChunks(conn, chunks, readErr, done)
 
-	var bytesRead int
-	var hasReceivedData bool
-	var err error
+	silence := time.NewTimer(p.frameSilence)
+	silence.Stop()
+	defer silence.Stop()
+	receiving := false
 
 	for {
 		select {
 		case <-s.portsCloseChan:
-			return
-		default:
-		}
+			return nil
 
-		switch s.ListenState.state {
+		case err := <-readErr:
+			return err
 
-		case InitialState:
-			s.ListenState.hasErr = false
-			bytesRead, err = port.Read(buffer)
-
-			if err != nil {
-				log.Print("InitialState err", err)
+		case chunk := <-chunks:
+			if receiving && !silence.Stop() {
+				<-silence.C
 			}
+			receiving = true
+			silence.Reset(p.frameSilence)
+			s.checkCharSilence(p, len(chunk))
+			p.packet = append(p.packet, chunk...)
+			s.splitSerialFrames(p)
 
-			if bytesRead == 0 {
-				hasReceivedData = false
-				s.ListenState.state = ReceiveState
-				continue
+		case <-silence.C:
+			// End of frame. A frame of unknown length is all that was
+			// received, a frame of known length is incomplete. Frames of
+			// other devices are not checked.
+			if !p.hasErr && len(p.packet) > 0 {
+				if !s.servesSerialUnit(p.packet[0]) {
+					p.diagnostics.increment(busMessageCount)
+				} else if rtuRequestLength(p.packet) < 0 {
+					s.acceptSerialPacket(p, p.packet)
+				} else {
+					p.diagnostics.increment(busMessageCount)
+					p.diagnostics.increment(busCommunicationErrorCount)
+					p.diagnostics.addEvent(eventReceive | eventReceiveCommunicationError)
+				}
 			}
+			p.packet = nil
+			p.hasErr = false
+			receiving = false
+		}
+	}
+}
 
-		case ReceiveState:
-			if !hasReceivedData {
-				s.ListenState.packet = []byte{}
+// readRTUChunks passes the bytes read from a stream to serveRTU until reading
+// fails or serveRTU returns.
+func readRTUChunks(conn io.Reader, chunks chan<- []byte, readErr chan<- error, done <-chan struct{}) {
+	buffer := make([]byte, 256)
+	for {
+		bytesRead, err := conn.Read(buffer)
+		if bytesRead > 0 {
+			chunk := make([]byte, bytesRead)
+			copy(chunk, buffer)
+			select {
+			case chunks <- chunk:
+			case <-done:
+				return
 			}
+		}
+		if err != nil {
+			readErr <- err
+			return
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
 
-			bytesRead, err := port.Read(buffer)
-			if err != nil {
-				log.Print("Receive state err", err)
-			}
+// checkCharSilence discards the frame being received if a silent interval
+// longer than t1.5 preceded the chunk of the given length. The time taken to
+// transmit the chunk is not part of the interval. The bytes are discarded
+// until the end of frame silence.
+func (s *Server) checkCharSilence(p *rtuPort, length int) {
+	now := time.Now()
+	last := p.lastChunk
+	p.lastChunk = now
+	if p.charSilence <= 0 || p.hasErr || len(p.packet) == 0 || !s.servesSerialUnit(p.packet[0]) {
+		return
+	}
+	// t1.5 is 1.5 characters long.
+	gap := now.Sub(last) - time.Duration(length)*p.charSilence*2/3
+	if gap > p.charSilence {
+		p.diagnostics.increment(busMessageCount)
+		p.diagnostics.increment(busCommunicationErrorCount)
+		p.diagnostics.addEvent(eventReceive | eventReceiveCommunicationError)
+		p.hasErr = true
+	}
+}
 
-			if bytesRead == 0 && hasReceivedData {
-				s.ListenState.state = ControlState
+// splitSerialFrames accepts the complete frames of known length at the start
+// of the received bytes. After an error the bytes are discarded until the end
+// of frame silence, as the frame boundaries are lost. Frames addressed to
+// other devices of the line, their responses included, only end with the
+// frame silence.
+func (s *Server) splitSerialFrames(p *rtuPort) {
+	for !p.hasErr && len(p.packet) > 0 && s.servesSerialUnit(p.packet[0]) {
+		length := rtuRequestLength(p.packet)
+		if length <= 0 || length > len(p.packet) {
+			break
+		}
+		packet := p.packet[:length:length]
+		p.packet = p.packet[length:]
+		if !s.acceptSerialPacket(p, packet) {
+			p.hasErr = true
+		}
+	}
 
-			} else if bytesRead > 0 {
-				hasReceivedData = true
-				s.ListenState.packet = append(s.ListenState.packet, buffer[0:bytesRead]...)
-			}
+	if !p.hasErr && len(p.packet) > 256 {
+		p.diagnostics.increment(busMessageCount)
+		p.diagnostics.increment(busCharacterOverrunCount)
+		p.diagnostics.addEvent(eventReceive | eventReceiveCharacterOverrun)
+		p.hasErr = true
+	}
+	if p.hasErr {
+		p.packet = p.packet[:0]
+	}
+}
 
-		case ControlState:
-			hasReceivedData = false
-			frame, err := NewRTUFrame(s.ListenState.packet)
-			if err != nil {
-				s.ListenState.state = InitialState
-				continue
-			}
+// servesSerialUnit returns true if requests for a unit ID received on a serial
+// line are handled by the server: broadcasts, slaves and serial routes.
+func (s *Server) servesSerialUnit(unitID byte) bool {
+	return unitID == 0 || (unitID >= s.lowerSlaveId && unitID <= s.upperSlaveId) || s.serialRoutes[unitID] != nil
+}
 
-			request := &Request{port, frame}
-			s.requestChan <- request
-			s.ListenState.state = ReceiveState
-		}
+// acceptSerialPacket passes a received frame to the handler. It returns false
+// if the frame is not valid.
+func (s *Server) acceptSerialPacket(p *rtuPort, packet []byte) bool {
+	p.diagnostics.increment(busMessageCount)
+	frame, err := NewRTUFrame(packet)
+	if err != nil {
+		p.diagnostics.increment(busCommunicationErrorCount)
+		p.diagnostics.addEvent(eventReceive | eventReceiveCommunicationError)
+		return false
+	}
+
+	request := &Request{
+		conn:        p.conn,
+		frame:       frame,
+		diagnostics: p.diagnostics,
+		transport:   TransportRTU,
+		remoteAddr:  p.remoteAddr,
+		connID:      p.connID,
+		received:    time.Now(),
 	}
+	s.requestChan <- request
+	return true
 }
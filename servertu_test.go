@@ -0,0 +1,288 @@
+package mbserver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+func TestRTUTiming(t *testing.T) {
+	tests := []struct {
+		mode         serial.Mode
+		charSilence  time.Duration
+		frameSilence time.Duration
+	}{
+		// 11 bits per character: 1145.8us per character.
+		{serial.Mode{BaudRate: 9600, DataBits: 8, Parity: serial.EvenParity}, 1718750 * time.Nanosecond, 4010416 * time.Nanosecond},
+		// 10 bits per character: 520.8us per character.
+		{serial.Mode{BaudRate: 19200}, 781250 * time.Nanosecond, 1822916 * time.Nanosecond},
+		{serial.Mode{BaudRate: 115200}, 750 * time.Microsecond, 1750 * time.Microsecond},
+	}
+	for _, test := range tests {
+		charSilence, frameSilence := rtuTiming(&test.mode)
+		if charSilence != test.charSilence || frameSilence != test.frameSilence {
+			t.Errorf("%+v: expected %v %v, got %v %v", test.mode, test.charSilence, test.frameSilence, charSilence, frameSilence)
+		}
+	}
+}
+
+func TestListenRTUMissingPort(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+
+	err := s.ListenRTU("/dev/mbserver-missing", &serial.Mode{BaudRate: 19200})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestListenRTUSupervised(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+
+	states := make(chan PortState, 16)
+	s.ListenRTUSupervised("/dev/mbserver-missing", &serial.Mode{BaudRate: 19200}, SupervisorConfig{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+		StateChanged: func(name string, state PortState, err error) {
+			if state == PortFailed && err == nil {
+				t.Errorf("expected error for %v", state)
+			}
+			states <- state
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		state := <-states
+		if state != PortFailed {
+			t.Errorf("expected %v, got %v", PortFailed, state)
+		}
+	}
+
+	s.Close()
+	var state PortState
+	for state = range states {
+		if state != PortFailed {
+			break
+		}
+	}
+	if state != PortClosed {
+		t.Errorf("expected %v, got %v", PortClosed, state)
+	}
+}
+
+// readRTUResponse reads a response of the given length from an in-process
+// RTU stream.
+func readRTUResponse(t *testing.T, conn net.Conn, length int) []byte {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response := make([]byte, length)
+	_, err := io.ReadFull(conn, response)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	return response
+}
+
+func TestServeRTU(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+
+	server, client := net.Pipe()
+	go s.ServeRTU(server, RTUOptions{FrameSilence: 20 * time.Millisecond})
+
+	// Back-to-back frames written at once.
+	write := RTUFrame{Address: 1, Function: 6, Data: []byte{0, 10, 0x12, 0x34}}
+	read := RTUFrame{Address: 1, Function: 3, Data: []byte{0, 10, 0, 1}}
+	client.Write(append(write.Bytes(), read.Bytes()...))
+
+	expect := write.Bytes()
+	got := readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	response := RTUFrame{Address: 1, Function: 3, Data: []byte{2, 0x12, 0x34}}
+	expect = response.Bytes()
+	got = readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// A frame split across writes.
+	packet := read.Bytes()
+	client.Write(packet[:3])
+	time.Sleep(5 * time.Millisecond)
+	client.Write(packet[3:])
+	got = readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// A truncated frame is discarded at the end of frame silence.
+	client.Write(packet[:5])
+	time.Sleep(50 * time.Millisecond)
+	client.Write(packet)
+	got = readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestServeRTUQueryData(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+
+	server, client := net.Pipe()
+	go s.ServeRTU(server, RTUOptions{FrameSilence: 20 * time.Millisecond})
+
+	// Return Query Data requests have data of any length, they end with the
+	// frame silence.
+	query := RTUFrame{Address: 1, Function: 8, Data: []byte{0, 0, 1, 2, 3, 4}}
+	client.Write(query.Bytes())
+	expect := query.Bytes()
+	got := readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestServeRTUClose(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.ServeRTU(server, RTUOptions{})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ServeRTU did not return")
+	}
+
+	// The stream is closed.
+	_, err := client.Write([]byte{0})
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestServeRTUOtherDevices(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+
+	server, client := net.Pipe()
+	go s.ServeRTU(server, RTUOptions{FrameSilence: 20 * time.Millisecond})
+
+	// A request to unit 2 and its response, which length does not follow the
+	// request rules, are not communication errors.
+	request := RTUFrame{Address: 2, Function: 3, Data: []byte{0, 0, 0, 2}}
+	client.Write(request.Bytes())
+	time.Sleep(50 * time.Millisecond)
+	response := RTUFrame{Address: 2, Function: 3, Data: []byte{4, 0, 1, 0, 2}}
+	client.Write(response.Bytes())
+	time.Sleep(50 * time.Millisecond)
+
+	for _, test := range []struct {
+		subFunction uint16
+		expect      byte
+	}{
+		{diagReturnBusMessageCount, 3},
+		{diagReturnBusMessageCount + 1, 0}, // bus communication error count
+	} {
+		diag := RTUFrame{Address: 1, Function: 8, Data: []byte{0, byte(test.subFunction), 0, 0}}
+		client.Write(diag.Bytes())
+		expect := RTUFrame{Address: 1, Function: 8, Data: []byte{0, byte(test.subFunction), 0, test.expect}}
+		got := readRTUResponse(t, client, len(expect.Bytes()))
+		if !isEqual(expect.Bytes(), got) {
+			t.Errorf("expected %v, got %v", expect.Bytes(), got)
+		}
+	}
+}
+
+func TestServeRTUCharSilence(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+
+	server, client := net.Pipe()
+	go s.ServeRTU(server, RTUOptions{FrameSilence: 60 * time.Millisecond, CharSilence: 5 * time.Millisecond})
+
+	// A frame with a gap longer than t1.5 is discarded.
+	read := RTUFrame{Address: 1, Function: 3, Data: []byte{0, 10, 0, 1}}
+	packet := read.Bytes()
+	client.Write(packet[:3])
+	time.Sleep(30 * time.Millisecond)
+	client.Write(packet[3:])
+	time.Sleep(100 * time.Millisecond)
+
+	client.Write(packet)
+	response := RTUFrame{Address: 1, Function: 3, Data: []byte{2, 0, 0}}
+	expect := response.Bytes()
+	got := readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	diag := RTUFrame{Address: 1, Function: 8, Data: []byte{0, diagReturnBusMessageCount + 1, 0, 0}}
+	client.Write(diag.Bytes())
+	response = RTUFrame{Address: 1, Function: 8, Data: []byte{0, diagReturnBusMessageCount + 1, 0, 1}}
+	expect = response.Bytes()
+	got = readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestRTUOptionsCharSilence(t *testing.T) {
+	mode := &serial.Mode{BaudRate: 115200}
+	tests := []struct {
+		opts   RTUOptions
+		expect time.Duration
+	}{
+		{RTUOptions{}, 0},
+		{RTUOptions{Mode: mode}, 0},
+		{RTUOptions{Mode: mode, CharSilence: -1}, 0},
+		{RTUOptions{Mode: mode, CharSilence: time.Millisecond}, time.Millisecond},
+	}
+	for _, test := range tests {
+		got := test.opts.charSilence()
+		if test.expect != got {
+			t.Errorf("%+v: expected %v, got %v", test.opts, test.expect, got)
+		}
+	}
+}
+
+func TestServeRTUCommEvents(t *testing.T) {
+	s := NewServer(1, 1, 0, 0)
+	defer s.Close()
+
+	server, client := net.Pipe()
+	go s.ServeRTU(server, RTUOptions{FrameSilence: 20 * time.Millisecond})
+
+	// Requests of functions 11 and 12 have no data.
+	request := RTUFrame{Address: 1, Function: 11, Data: []byte{}}
+	client.Write(request.Bytes())
+	response := RTUFrame{Address: 1, Function: 11, Data: []byte{0, 0, 0, 0}}
+	expect := response.Bytes()
+	got := readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	request.Function = 12
+	client.Write(request.Bytes())
+	response = RTUFrame{Address: 1, Function: 12, Data: []byte{9, 0, 0, 0, 0, 0, 2, 0x80, 0x40, 0x80}}
+	expect = response.Bytes()
+	got = readRTUResponse(t, client, len(expect))
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
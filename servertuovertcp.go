@@ -0,0 +1,87 @@
+package mbserver
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// ListenRTUOverTCP starts the Modbus server listening on "address:port" for
+// RTU frames tunnelled over TCP, as sent by serial device servers.
+func (s *Server) ListenRTUOverTCP(addressPort string) (err error) {
+	listen, err := net.Listen("tcp", addressPort)
+	if err != nil {
+		log.Printf("Failed to Listen: %v\n", err)
+		return err
+	}
+	s.listeners = append(s.listeners, listen)
+	go s.acceptRTUOverTCP(listen)
+	return err
+}
+
+func (s *Server) acceptRTUOverTCP(listen net.Listener) error {
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+			log.Printf("Unable to accept connections: %#v\n", err)
+			return err
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			connID := s.newConnID()
+
+			buffer := make([]byte, 512)
+			var packet []byte
+			for {
+				bytesRead, err := conn.Read(buffer)
+				if err != nil {
+					if err != io.EOF {
+						log.Printf("read error %v\n", err)
+					}
+					return
+				}
+				packet = append(packet, buffer[:bytesRead]...)
+
+				// Split the stream into frames by their expected length.
+				for len(packet) > 0 {
+					length := rtuRequestLength(packet)
+					if length < 0 {
+						// The length is unknown, assume the frame is all
+						// that was received.
+						length = len(packet)
+					}
+					if length > 256 {
+						log.Printf("bad packet error: %d byte RTU frame\n", length)
+						return
+					}
+					if length == 0 || length > len(packet) {
+						break
+					}
+
+					frame, err := NewRTUFrame(packet[:length:length])
+					if err != nil {
+						log.Printf("bad packet error %v\n", err)
+						return
+					}
+					packet = packet[length:]
+
+					request := &Request{
+						conn:       conn,
+						frame:      frame,
+						transport:  TransportRTUOverTCP,
+						remoteAddr: conn.RemoteAddr(),
+						connID:     connID,
+						received:   time.Now(),
+					}
+					s.requestChan <- request
+				}
+			}
+		}(conn)
+	}
+}
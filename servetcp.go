@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"strings"
+	"time"
 )
 
 func (s *Server) accept(listen net.Listener) error {
@@ -22,32 +23,87 @@ func (s *Server) accept(listen net.Listener) error {
 		go func(conn net.Conn) {
 			defer conn.Close()
 
+			role, err := connRole(conn)
+			if err != nil {
+				log.Printf("TLS error %v\n", err)
+				return
+			}
+			transport := TransportTCP
+			var tlsState *tls.ConnectionState
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				transport = TransportTLS
+				state := tlsConn.ConnectionState()
+				tlsState = &state
+			}
+			connID := s.newConnID()
+
+			buffer := make([]byte, 512)
+			var packet []byte
 			for {
-				packet := make([]byte, 512)
-				bytesRead, err := conn.Read(packet)
+				bytesRead, err := conn.Read(buffer)
 				if err != nil {
 					if err != io.EOF {
 						log.Printf("read error %v\n", err)
 					}
 					return
 				}
-				// Set the length of the packet to the number of read bytes.
-				packet = packet[:bytesRead]
+				packet = append(packet, buffer[:bytesRead]...)
 
-				frame, err := NewTCPFrame(packet)
-				if err != nil {
-					log.Printf("bad packet error %v\n", err)
-					return
-				}
+				// Split the stream into frames by their MBAP length, a read
+				// may hold part of a frame or several pipelined frames.
+				for {
+					length, err := tcpFrameLength(packet)
+					if err != nil {
+						log.Printf("bad packet error %v\n", err)
+						return
+					}
+					if length == 0 || length > len(packet) {
+						break
+					}
+
+					frame, err := NewTCPFrame(packet[:length:length])
+					if err != nil {
+						log.Printf("bad packet error %v\n", err)
+						return
+					}
+					packet = packet[length:]
 
-				request := &Request{conn, frame}
+					request := &Request{
+						conn:       conn,
+						frame:      frame,
+						role:       role,
+						transport:  transport,
+						remoteAddr: conn.RemoteAddr(),
+						connID:     connID,
+						tlsState:   tlsState,
+						received:   time.Now(),
+					}
 
-				s.requestChan <- request
+					s.requestChan <- request
+				}
 			}
 		}(conn)
 	}
 }
 
+// connRole completes the TLS handshake of a connection and returns the role of
+// the client certificate. Connections without TLS have no role.
+func connRole(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	err := tlsConn.Handshake()
+	if err != nil {
+		return "", err
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", nil
+	}
+	return certificateRole(certs[0])
+}
+
 // ListenTCP starts the Modbus server listening on "address:port".
 func (s *Server) ListenTCP(addressPort string) (err error) {
 	listen, err := net.Listen("tcp", addressPort)
@@ -61,6 +117,8 @@ func (s *Server) ListenTCP(addressPort string) (err error) {
 }
 
 // ListenTLS starts the Modbus server listening on "address:port".
+// Set config.ClientAuth to request client certificates, whose Modbus role
+// extension is checked against the authorization policy.
 func (s *Server) ListenTLS(addressPort string, config *tls.Config) (err error) {
 	listen, err := tls.Listen("tcp", addressPort, config)
 	if err != nil {
@@ -0,0 +1,82 @@
+package mbserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// udpConn writes responses back to the sender of a datagram.
+type udpConn struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+func (c *udpConn) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("read from udp response connection")
+}
+
+func (c *udpConn) Write(p []byte) (int, error) {
+	return c.conn.WriteTo(p, c.addr)
+}
+
+// Close does nothing, the packet connection is shared by all senders.
+func (c *udpConn) Close() error {
+	return nil
+}
+
+func (s *Server) acceptUDP(conn net.PacketConn) error {
+	// Each sender has its own connection ID.
+	connIDs := make(map[string]uint64)
+	for {
+		// A Modbus TCP ADU is at most 260 bytes, leave room to detect
+		// oversized datagrams.
+		packet := make([]byte, 512)
+		bytesRead, addr, err := conn.ReadFrom(packet)
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+			log.Printf("read error %v\n", err)
+			return err
+		}
+
+		frame, err := NewTCPFrame(packet[:bytesRead])
+		if err != nil {
+			log.Printf("bad packet error %v\n", err)
+			continue
+		}
+
+		connID, ok := connIDs[addr.String()]
+		if !ok {
+			connID = s.newConnID()
+			connIDs[addr.String()] = connID
+		}
+
+		request := &Request{
+			conn:       &udpConn{conn, addr},
+			frame:      frame,
+			transport:  TransportUDP,
+			remoteAddr: addr,
+			connID:     connID,
+			received:   time.Now(),
+		}
+
+		s.requestChan <- request
+	}
+}
+
+// ListenUDP starts the Modbus server listening on "address:port" for Modbus
+// TCP frames carried in UDP datagrams.
+func (s *Server) ListenUDP(addressPort string) (err error) {
+	conn, err := net.ListenPacket("udp", addressPort)
+	if err != nil {
+		log.Printf("Failed to Listen on UDP: %v\n", err)
+		return err
+	}
+	s.packetConns = append(s.packetConns, conn)
+	go s.acceptUDP(conn)
+	return err
+}
@@ -0,0 +1,95 @@
+package mbserver
+
+import (
+	"fmt"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// PortState is the state of a serial port reported by ListenRTUSupervised.
+type PortState int
+
+const (
+	// PortOpen the port is open and served.
+	PortOpen PortState = iota
+	// PortFailed the port could not be opened or failed while served, it is
+	// opened again after a backoff.
+	PortFailed
+	// PortClosed the server was closed.
+	PortClosed
+)
+
+func (state PortState) String() string {
+	switch state {
+	case PortOpen:
+		return "PortOpen"
+	case PortFailed:
+		return "PortFailed"
+	case PortClosed:
+		return "PortClosed"
+	}
+	return fmt.Sprintf("PortState(%d)", int(state))
+}
+
+// SupervisorConfig configures ListenRTUSupervised.
+type SupervisorConfig struct {
+	// MinBackoff is the delay before the first retry, doubled on each
+	// consecutive failure up to MaxBackoff. Defaults to 500ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// StateChanged, if set, is called when the port is opened, on every failed
+	// attempt to open or serve it, with the error, and when it is closed.
+	StateChanged func(name string, state PortState, err error)
+}
+
+// ListenRTUSupervised starts the Modbus server listening to a serial device
+// that may be missing or unplugged. The device is opened in the background,
+// retrying with backoff until it is available, and opened again whenever it
+// fails, so serving resumes when an adapter is plugged back in.
+func (s *Server) ListenRTUSupervised(name string, mode *serial.Mode, config SupervisorConfig) {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 500 * time.Millisecond
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 30 * time.Second
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+	stateChanged := func(state PortState, err error) {
+		if config.StateChanged != nil {
+			config.StateChanged(name, state, err)
+		}
+	}
+
+	s.portsWG.Add(1)
+	go func() {
+		defer s.portsWG.Done()
+		defer stateChanged(PortClosed, nil)
+
+		backoff := config.MinBackoff
+		for {
+			port, err := openRTUPort(name, mode)
+			if err == nil {
+				backoff = config.MinBackoff
+				stateChanged(PortOpen, nil)
+				err = s.serveRTU(port, RTUOptions{Mode: mode})
+				if err == nil {
+					return
+				}
+			}
+			stateChanged(PortFailed, err)
+
+			select {
+			case <-s.portsCloseChan:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > config.MaxBackoff {
+				backoff = config.MaxBackoff
+			}
+		}
+	}()
+}